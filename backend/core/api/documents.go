@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context-fabric/backend/core/documents"
+	"context-fabric/backend/core/domain"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxUploadBytes 是单次上传的请求体上限。
+const maxUploadBytes = 32 << 20
+
+// DocumentHandler 处理 RAG 知识库文档的导入与管理请求
+type DocumentHandler struct{ svc *documents.Service }
+
+func NewDocumentHandler(s *documents.Service) *DocumentHandler { return &DocumentHandler{svc: s} }
+
+// ServeDocuments 路由：
+//
+//	GET    /api/admin/documents        文档列表
+//	POST   /api/admin/documents        导入文档：multipart/form-data 上传一个或多个 file，或 JSON IngestRequest
+//	GET    /api/admin/documents/{id}   文档详情（含分块）
+//	DELETE /api/admin/documents/{id}   删除文档及其向量
+func (h *DocumentHandler) ServeDocuments(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/documents"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "multipart/form-data" {
+			h.upload(w, r)
+			return
+		}
+		var req documents.IngestRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		doc, err := h.svc.Ingest(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), documentErrorStatus(err))
+			return
+		}
+		writeJSON(w, http.StatusCreated, doc.Summary())
+	case id == "":
+		list, err := h.svc.List(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	case r.Method == http.MethodDelete:
+		if err := h.svc.Delete(r.Context(), id); err != nil {
+			http.Error(w, err.Error(), documentErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		doc, err := h.svc.Get(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), documentErrorStatus(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, doc)
+	}
+}
+
+// upload 逐个导入上传的文件。表单字段 chunk_size、chunk_overlap、collection、embedding_model、format
+// 对全部文件生效；title 与 id 仅在上传单个文件时使用。任一文件失败即返回错误，已导入的文件保留。
+func (h *DocumentHandler) upload(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxUploadBytes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		http.Error(w, "missing file field", http.StatusBadRequest)
+		return
+	}
+
+	base := documents.IngestRequest{
+		Format:         r.FormValue("format"),
+		Collection:     r.FormValue("collection"),
+		EmbeddingModel: r.FormValue("embedding_model"),
+	}
+	var err error
+	if base.Size, err = formInt(r, "chunk_size"); err == nil {
+		base.Overlap, err = formInt(r, "chunk_overlap")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(files) == 1 {
+		base.ID, base.Title = r.FormValue("id"), r.FormValue("title")
+	}
+
+	ingested := []domain.DocumentSummary{}
+	for _, fh := range files {
+		req := base
+		req.Source = fh.Filename
+		if req.Content, err = readUpload(fh); err == nil {
+			var doc *domain.Document
+			if doc, err = h.svc.Ingest(r.Context(), req); err == nil {
+				ingested = append(ingested, doc.Summary())
+				continue
+			}
+		}
+		http.Error(w, fmt.Sprintf("%s: %v", fh.Filename, err), documentErrorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, ingested)
+}
+
+func readUpload(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// formInt 解析可选的整数表单字段，缺省时返回 0。
+func formInt(r *http.Request, key string) (int, error) {
+	v := r.FormValue(key)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, nil
+}
+
+// documentErrorStatus 区分请求错误、文档不存在与向量库/网关等下游失败。
+func documentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, documents.ErrInvalidDocument):
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
@@ -5,9 +5,12 @@ import (
 	"context-fabric/backend/core/domain"
 	"context-fabric/backend/core/history"
 	"context-fabric/backend/core/persistence"
+	"context-fabric/backend/core/pipeline"
+	"context-fabric/backend/core/tokens"
 	"encoding/json"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -49,21 +52,56 @@ func (h *ContextHandler) AppendMessage(w http.ResponseWriter, r *http.Request) {
 
 // GetContext 获取经过 Pipeline 优化后的上下文负载
 func (h *ContextHandler) GetContext(w http.ResponseWriter, r *http.Request) {
+	var req context.BuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msgs, _ := h.svc.BuildContext(r.Context(), req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"messages": msgs})
+}
+
+// ExplainContext 以 dry-run 方式运行管线（不写入用户消息），返回最终负载与逐 Pass 的影响分析
+func (h *ContextHandler) ExplainContext(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req context.BuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.SessionID == "" {
+		http.Error(w, "session_id is required", http.StatusBadRequest)
+		return
+	}
+	exp, err := h.svc.Explain(r.Context(), req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exp)
+}
+
+// CountTokens 按与管线相同的模型目录与计数口径，返回消息列表逐条与总计的 Token 数
+func (h *ContextHandler) CountTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 	var req struct {
-		SessionID         string `json:"session_id"`
-		Query             string `json:"query"`
-		ModelID           string `json:"model_id"`
-		RagEnabled        bool   `json:"rag_enabled"`
-		RagEmbeddingModel string `json:"rag_embedding_model"`
-		SanitizationModel string `json:"sanitization_model_id"`
+		ModelID  string           `json:"model_id"`
+		Messages []domain.Message `json:"messages"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	msgs, _ := h.svc.GetOptimizedContext(r.Context(), req.SessionID, req.Query, req.ModelID, req.RagEnabled, req.RagEmbeddingModel, req.SanitizationModel)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"messages": msgs})
+	json.NewEncoder(w).Encode(tokens.CountBreakdown(req.ModelID, req.Messages))
 }
 
 // AdminHandler 处理会话管理和测试用例相关的管理端请求
@@ -87,6 +125,21 @@ func (h *AdminHandler) GetMemoryStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(state)
 }
 
+// ServePassRegistry 列出所有已注册的 Pass 及其参数 Schema，供配置编辑与排查使用
+func (h *AdminHandler) ServePassRegistry(w http.ResponseWriter, r *http.Request) {
+	defs := pipeline.Definitions()
+	list := make([]map[string]interface{}, 0, len(defs))
+	for _, def := range defs {
+		list = append(list, map[string]interface{}{
+			"name":        def.Name,
+			"description": def.Description,
+			"params":      def.Schema(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
 func (h *AdminHandler) parseID(r *http.Request) string {
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
 	if len(parts) >= 4 {
@@ -139,6 +192,10 @@ func (h *AdminHandler) ServeTestCases(w http.ResponseWriter, r *http.Request) {
 
 func (h *AdminHandler) ServeSessions(w http.ResponseWriter, r *http.Request) {
 	id := h.parseID(r)
+	if id != "" && strings.HasSuffix(strings.TrimRight(r.URL.Path, "/"), "/snapshot") {
+		h.serveSnapshot(w, r, id)
+		return
+	}
 	if id != "" {
 		if r.Method == http.MethodDelete {
 			h.history.Delete(r.Context(), id)
@@ -173,6 +230,52 @@ func (h *AdminHandler) ServeSessions(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(list)
 }
 
+// serveSnapshot 回放消息 Trace 中记录的逐 Pass 消息差异，重建指定 Pass 执行后的完整上下文。
+// GET /api/admin/sessions/{id}/snapshot?pass=<Pass 序号或名称>&message=<消息下标，默认为最后一条带 Trace 的消息>
+func (h *AdminHandler) serveSnapshot(w http.ResponseWriter, r *http.Request, id string) {
+	session, err := h.history.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	msgIdx := -1
+	if v := r.URL.Query().Get("message"); v != "" {
+		if msgIdx, err = strconv.Atoi(v); err != nil || msgIdx < 0 || msgIdx >= len(session.Messages) {
+			http.Error(w, "invalid message index", http.StatusBadRequest)
+			return
+		}
+	} else {
+		for i := len(session.Messages) - 1; i >= 0; i-- {
+			if len(session.Messages[i].Traces) > 0 {
+				msgIdx = i
+				break
+			}
+		}
+		if msgIdx < 0 {
+			http.Error(w, "no traced message in session", http.StatusNotFound)
+			return
+		}
+	}
+
+	pass := r.URL.Query().Get("pass")
+	if pass == "" {
+		http.Error(w, "pass is required", http.StatusBadRequest)
+		return
+	}
+	snap, err := pipeline.ReplaySnapshot(session.Messages[msgIdx].Traces, pass)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session_id": id,
+		"message":    msgIdx,
+		"snapshot":   snap,
+	})
+}
+
 func (h *AdminHandler) ServeVectors(w http.ResponseWriter, r *http.Request) {
 	if h.vectorRepo == nil {
 		http.Error(w, "Vector repository not configured", http.StatusNotImplemented)
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context-fabric/backend/core/prompts"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// PromptHandler 处理系统提示词模板的管理请求
+type PromptHandler struct{ svc *prompts.Service }
+
+func NewPromptHandler(s *prompts.Service) *PromptHandler { return &PromptHandler{svc: s} }
+
+// ServePrompts 路由：
+//
+//	GET    /api/admin/prompts                     模板列表
+//	POST   /api/admin/prompts                     发布新版本 {app_id, content, author, comment}
+//	GET    /api/admin/prompts/{app_id}            模板详情（含全部版本）
+//	DELETE /api/admin/prompts/{app_id}            删除模板
+//	POST   /api/admin/prompts/{app_id}/rollback   以历史版本发布新版本 {version, author}
+//	POST   /api/admin/prompts/{app_id}/preview    以示例变量渲染 {content}，content 为空时渲染当前版本
+func (h *PromptHandler) ServePrompts(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/prompts"), "/"), "/")
+	appID, action := parts[0], ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+
+	switch {
+	case appID == "" && r.Method == http.MethodPost:
+		var req struct {
+			AppID   string `json:"app_id"`
+			Content string `json:"content"`
+			Author  string `json:"author"`
+			Comment string `json:"comment"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v, err := h.svc.Publish(r.Context(), req.AppID, req.Content, req.Author, req.Comment)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, v)
+	case appID == "":
+		list, err := h.svc.List(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	case action == "rollback" && r.Method == http.MethodPost:
+		var req struct {
+			Version int    `json:"version"`
+			Author  string `json:"author"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v, err := h.svc.Rollback(r.Context(), appID, req.Version, req.Author)
+		if err != nil {
+			http.Error(w, err.Error(), promptErrorStatus(err))
+			return
+		}
+		writeJSON(w, http.StatusCreated, v)
+	case action == "preview" && r.Method == http.MethodPost:
+		var req struct {
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.preview(w, r, appID, req.Content)
+	case action != "":
+		http.Error(w, "not found", http.StatusNotFound)
+	case r.Method == http.MethodDelete:
+		if err := h.svc.Delete(r.Context(), appID); err != nil {
+			http.Error(w, err.Error(), promptErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		t, err := h.svc.Get(r.Context(), appID)
+		if err != nil {
+			http.Error(w, err.Error(), promptErrorStatus(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+	}
+}
+
+// preview 以示例变量渲染给定内容；content 为空时渲染该 AppID 当前生效的模板（含回退）。
+func (h *PromptHandler) preview(w http.ResponseWriter, r *http.Request, appID, content string) {
+	vars := prompts.SampleVars()
+	vars.AppID = appID
+	resp := map[string]interface{}{"vars": vars}
+	if content == "" {
+		out, version, err := h.svc.Render(r.Context(), appID, vars)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp["content"], resp["version"] = out, version
+	} else {
+		tmpl, err := prompts.Parse("preview", content)
+		if err == nil {
+			resp["content"], err = prompts.Execute(tmpl, vars)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func promptErrorStatus(err error) int {
+	if errors.Is(err, fs.ErrNotExist) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,140 @@
+package context
+
+import (
+	stdctx "context"
+	"context-fabric/backend/core/domain"
+	"context-fabric/backend/core/pipeline"
+	"context-fabric/backend/core/tokens"
+	"fmt"
+	"time"
+)
+
+// injectedAttrs 是检索类 Pass 在 Span 中记录的注入内容，explain 会原样返回。
+var injectedAttrs = []string{"rag_snippets", "rag_citations", "injected_memories", "injected_facts"}
+
+// ExplainMessage 是 explain 结果中的单条消息摘要。
+type ExplainMessage struct {
+	Index   int    `json:"index"` // 在该 Pass 执行前的消息列表中的下标
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	Tokens  int    `json:"tokens"`
+}
+
+// PassExplain 描述单个 Pass 对上下文的影响。
+type PassExplain struct {
+	Pass           string                 `json:"pass"`
+	Status         string                 `json:"status"`
+	Outcome        string                 `json:"outcome,omitempty"`
+	Reason         string                 `json:"reason,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+	DurationMs     int64                  `json:"duration_ms"`
+	MessagesBefore int                    `json:"messages_before"`
+	MessagesAfter  int                    `json:"messages_after"`
+	TokensBefore   int                    `json:"tokens_before"`
+	TokensAfter    int                    `json:"tokens_after"`
+	TokensAdded    int                    `json:"tokens_added"`
+	TokensRemoved  int                    `json:"tokens_removed"`
+	Dropped        []ExplainMessage       `json:"dropped,omitempty"`  // 该 Pass 移除的消息（如 TokenLimitPass 截断、Summarizer 折叠）
+	Injected       map[string]interface{} `json:"injected,omitempty"` // 该 Pass 注入的记忆与 RAG 片段
+	Events         []pipeline.Event       `json:"events,omitempty"`
+}
+
+// Explanation 是一次 dry-run 的完整结果。
+type Explanation struct {
+	SessionID   string                 `json:"session_id"`
+	Profile     string                 `json:"profile"`
+	Payload     []domain.Message       `json:"payload"`
+	TotalTokens int                    `json:"total_tokens"`
+	DurationMs  int64                  `json:"duration_ms"`
+	Passes      []PassExplain          `json:"passes"`
+	Meta        map[string]interface{} `json:"meta"`
+}
+
+// Explain 以 dry-run 方式执行管线，用户提问不会写入历史。
+func (e *Engine) Explain(ctx stdctx.Context, req BuildRequest) (*Explanation, error) {
+	start := time.Now()
+	pending := []domain.Message{{Role: domain.RoleUser, Content: req.Query, Timestamp: time.Now()}}
+	data, err := e.run(ctx, req, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := tokens.ForModel(req.ModelID)
+	passes, err := explainPasses(data.Trace, counter)
+	if err != nil {
+		return nil, err
+	}
+	profile, _ := data.Meta["pipeline_profile"].(string)
+	return &Explanation{
+		SessionID:   req.SessionID,
+		Profile:     profile,
+		Payload:     data.Messages,
+		TotalTokens: counter.CountMessages(data.Messages),
+		DurationMs:  time.Since(start).Milliseconds(),
+		Passes:      passes,
+		Meta:        data.Meta,
+	}, nil
+}
+
+// explainPasses 按顺序回放各 Pass Span 记录的消息差异，以所选模型的口径统计每个 Pass 的 Token 增减与被移除的消息。
+func explainPasses(trace *pipeline.Trace, counter *tokens.Counter) ([]PassExplain, error) {
+	var snap []pipeline.SnapshotMessage
+	snapTokens := 0
+	out := make([]PassExplain, 0, len(trace.Spans))
+
+	for _, span := range trace.Spans {
+		pe := PassExplain{
+			Pass:           span.Name,
+			Status:         string(span.Status),
+			Outcome:        attrString(span, "outcome"),
+			Reason:         attrString(span, "reason"),
+			Error:          attrString(span, "error"),
+			DurationMs:     span.Duration().Milliseconds(),
+			MessagesBefore: len(snap),
+			TokensBefore:   snapTokens,
+			Events:         span.Events,
+		}
+
+		if diff, ok := span.Attrs["diff"].(pipeline.MessageDiff); ok {
+			for _, op := range diff.Ops {
+				switch op.Op {
+				case pipeline.DiffInsert:
+					pe.TokensAdded += counter.Count(op.Content) + counter.Spec.MessageOverhead
+				case pipeline.DiffModify:
+					pe.TokensAdded += counter.Count(op.Content)
+					pe.TokensRemoved += counter.Count(snap[op.OldIndex].Content)
+				case pipeline.DiffRemove:
+					old := snap[op.OldIndex]
+					t := counter.Count(old.Content) + counter.Spec.MessageOverhead
+					pe.TokensRemoved += t
+					pe.Dropped = append(pe.Dropped, ExplainMessage{Index: op.OldIndex, Role: old.Role, Content: old.Content, Tokens: t})
+				}
+			}
+			next, err := diff.Apply(snap)
+			if err != nil {
+				return nil, fmt.Errorf("explain pass %s: %w", span.Name, err)
+			}
+			snap = next
+			snapTokens += pe.TokensAdded - pe.TokensRemoved
+		}
+
+		for _, key := range injectedAttrs {
+			if v, ok := span.Attrs[key]; ok {
+				if pe.Injected == nil {
+					pe.Injected = make(map[string]interface{})
+				}
+				pe.Injected[key] = v
+			}
+		}
+
+		pe.MessagesAfter = len(snap)
+		pe.TokensAfter = snapTokens
+		out = append(out, pe)
+	}
+	return out, nil
+}
+
+func attrString(span *pipeline.Span, key string) string {
+	v, _ := span.Attrs[key].(string)
+	return v
+}
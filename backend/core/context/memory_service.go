@@ -12,6 +12,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
@@ -36,16 +38,22 @@ type MemoryState struct {
 	LastReflectionStatus         string    `json:"last_reflection_status"`
 	LastReflectionFactsProcessed int       `json:"last_reflection_facts_processed"`
 	LastReflectionInstructions   int       `json:"last_reflection_instructions"`
+
+	// 查询向量缓存 (跨请求共享的 LRU)
+	EmbeddingCacheHits   uint64 `json:"embedding_cache_hits"`
+	EmbeddingCacheMisses uint64 `json:"embedding_cache_misses"`
+	EmbeddingCacheSize   int    `json:"embedding_cache_size"`
 }
 
 // MemoryService 负责管理跨会话的长期记忆进化 (DEMA 架构)
 type MemoryService struct {
 	repo          domain.VectorRepository
 	llmServiceURL string
-	ingestChan    chan ingestTask // 异步清洗任务队列
-	state         MemoryState     // 系统实时状态
-	stateLock     sync.RWMutex    // 状态读写锁
-	memoryLogger  *log.Logger     // 专用的业务逻辑日志记录器
+	ingestChan    chan ingestTask      // 异步清洗任务队列
+	state         MemoryState          // 系统实时状态
+	stateLock     sync.RWMutex         // 状态读写锁
+	memoryLogger  *log.Logger          // 专用的业务逻辑日志记录器
+	embCache      *util.LRU[[]float32] // 查询向量缓存，避免重复调用网关
 }
 
 type ingestTask struct {
@@ -75,6 +83,7 @@ func NewMemoryService(repo domain.VectorRepository, llmURL string) *MemoryServic
 		llmServiceURL: llmURL,
 		ingestChan:    make(chan ingestTask, 100),
 		memoryLogger:  memLogger,
+		embCache:      util.NewLRU[[]float32](getEmbeddingCacheSize()),
 	}
 	go svc.worker()         // 启动快系统 Worker
 	go svc.reflectionLoop() // 启动慢系统 Ticker
@@ -87,9 +96,20 @@ func (s *MemoryService) GetState() MemoryState {
 	defer s.stateLock.RUnlock()
 	state := s.state
 	state.IngestQueueSize = len(s.ingestChan)
+	state.EmbeddingCacheHits, state.EmbeddingCacheMisses, state.EmbeddingCacheSize = s.embCache.Stats()
 	return state
 }
 
+// getEmbeddingCacheSize 读取查询向量缓存容量，设置为 0 可关闭缓存
+func getEmbeddingCacheSize() int {
+	size, err := strconv.Atoi(util.GetEnv("AGENTIC_EMBEDDING_CACHE_SIZE", "512"))
+	if err != nil || size < 0 {
+		log.Printf("[Memory] Invalid AGENTIC_EMBEDDING_CACHE_SIZE, falling back to 512")
+		return 512
+	}
+	return size
+}
+
 // logEvent 以 JSON 格式记录详细的业务输入输出，供调试与审计
 func (s *MemoryService) logEvent(system string, action string, details interface{}) {
 	entry := map[string]interface{}{
@@ -147,7 +167,7 @@ func (s *MemoryService) Reflect(ctx context.Context) error {
 		log.Printf("[Memory] Reflection: Processing fact [%s] (Session: %s)", fact.ID[:8], fact.SourceSession)
 
 		// 2. 检索相关旧记忆
-		related, err := s.repo.SearchSharedMemories(ctx, fact.Vector, 3)
+		related, err := s.repo.SearchSharedMemories(ctx, fact.Vector, 3, false)
 		if err != nil {
 			log.Printf("[Memory] Reflection ERROR: Search failed for fact %s: %v", fact.ID, err)
 			continue
@@ -282,25 +302,54 @@ func (s *MemoryService) Ingest(ctx context.Context, sessionID string, messages [
 }
 
 func (s *MemoryService) GetEmbedding(ctx context.Context, text string, modelID string) ([]float32, error) {
+	vector, _, err := s.GetEmbeddingWithCache(ctx, text, modelID)
+	return vector, err
+}
+
+// embeddingCacheKey 是跨请求向量缓存的 Key，按 (model, text) 区分。
+func embeddingCacheKey(text, modelID string) string {
+	return modelID + "\x00" + text
+}
+
+// GetEmbeddingWithCache 优先从跨请求的 LRU 缓存中获取向量，并返回是否命中缓存；
+// 写入与读出缓存时均复制向量，调用方修改返回值不会污染缓存。
+func (s *MemoryService) GetEmbeddingWithCache(ctx context.Context, text string, modelID string) ([]float32, bool, error) {
 	if modelID == "" {
 		modelID = "text-embedding-3-small" // 兜底
 	}
-	return s.getEmbedding(ctx, text, modelID)
+	key := embeddingCacheKey(text, modelID)
+	if vector, ok := s.embCache.Get(key); ok {
+		return slices.Clone(vector), true, nil
+	}
+
+	vector, err := s.getEmbedding(ctx, text, modelID)
+	if err != nil {
+		return nil, false, err
+	}
+	if vector != nil {
+		s.embCache.Put(key, slices.Clone(vector))
+	}
+	return vector, false, nil
 }
 
-func (s *MemoryService) Retrieve(ctx context.Context, vector []float32) (l1 []domain.SharedMemory, l2 []domain.StagingFact, err error) {
+// Retrieve 检索与查询向量最相近的长期记忆与近期事实，每层至多 limit 条（limit <= 0 时为 3）；
+// withVector 为 true 时结果带向量，供 MMR 使用。
+func (s *MemoryService) Retrieve(ctx context.Context, vector []float32, limit int, withVector bool) (l1 []domain.SharedMemory, l2 []domain.StagingFact, err error) {
 	if len(vector) == 0 {
 		return nil, nil, nil
 	}
+	if limit <= 0 {
+		limit = 3
+	}
 
 	// Layer 1: 长期背景 (Shared)
-	l1, err = s.repo.SearchSharedMemories(ctx, vector, 3)
+	l1, err = s.repo.SearchSharedMemories(ctx, vector, limit, withVector)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// Layer 2: 近期事实 (Staging)
-	l2, err = s.repo.SearchStagingFacts(ctx, vector, 3)
+	l2, err = s.repo.SearchStagingFacts(ctx, vector, limit, withVector)
 	if err != nil {
 		return nil, nil, err
 	}
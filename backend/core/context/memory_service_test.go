@@ -0,0 +1,42 @@
+package context
+
+import (
+	stdctx "context"
+	"context-fabric/backend/core/util"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEmbeddingCacheReturnsCopies(t *testing.T) {
+	calls := 0
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"data":[{"embedding":[1,2,3]}]}`))
+	}))
+	defer gateway.Close()
+
+	s := &MemoryService{llmServiceURL: gateway.URL, embCache: util.NewLRU[[]float32](8)}
+	first, hit, err := s.GetEmbeddingWithCache(stdctx.Background(), "q", "m")
+	if err != nil || hit {
+		t.Fatalf("first lookup: hit=%v err=%v", hit, err)
+	}
+	first[0] = 99
+
+	second, hit, err := s.GetEmbeddingWithCache(stdctx.Background(), "q", "m")
+	if err != nil || !hit {
+		t.Fatalf("second lookup: hit=%v err=%v", hit, err)
+	}
+	if second[0] != 1 {
+		t.Fatalf("cached vector was modified through the first result: %v", second)
+	}
+	second[1] = 99
+
+	third, _, _ := s.GetEmbeddingWithCache(stdctx.Background(), "q", "m")
+	if third[1] != 2 {
+		t.Fatalf("cached vector was modified through a cache hit: %v", third)
+	}
+	if calls != 1 {
+		t.Fatalf("gateway called %d times, want 1", calls)
+	}
+}
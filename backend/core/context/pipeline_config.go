@@ -0,0 +1,301 @@
+package context
+
+import (
+	"bytes"
+	"context-fabric/backend/core/pipeline"
+	// 引入 passes 包以完成内置 Pass 的注册
+	_ "context-fabric/backend/core/pipeline/passes"
+	"context-fabric/backend/core/prompts"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// PassSpec 描述管线中的一个处理步骤：Pass 名称、专属参数及容错策略。
+type PassSpec struct {
+	Name   string          `json:"name"`
+	Params json.RawMessage `json:"params,omitempty"`
+	// Policy 覆盖 Pass 默认的容错策略，仅替换出现的字段。
+	Policy json.RawMessage `json:"policy,omitempty"`
+	// When 覆盖 Pass 默认的守卫条件，全部满足时才执行；显式写 [] 表示无条件执行。
+	When []string `json:"when,omitempty"`
+	// Parallel 声明一组并发执行的独立 Pass（如多路检索），与 Name 互斥。
+	// 组内各 Pass 只能插入新消息，结果按声明顺序合并。
+	Parallel []PassSpec `json:"parallel,omitempty"`
+}
+
+// label 返回用于错误定位的步骤名称。
+func (s PassSpec) label() string {
+	if len(s.Parallel) > 0 {
+		return "parallel"
+	}
+	return s.Name
+}
+
+// DefaultProfileName 是未显式指定 default_profile 时使用的兜底 Profile 名称。
+const DefaultProfileName = "default"
+
+// ProfileConfig 是一个命名的管线定义，按数组顺序依次执行各个 Pass。
+type ProfileConfig struct {
+	Passes []PassSpec `json:"passes"`
+}
+
+// PipelineConfig 是声明式的管线配置，支持按 AppID 选择不同的 Profile。
+type PipelineConfig struct {
+	// Passes 兼容单管线写法，等价于定义名为 "default" 的 Profile。
+	Passes []PassSpec `json:"passes,omitempty"`
+	// DefaultProfile 未匹配到 AppID 时使用的 Profile，缺省为 "default"。
+	DefaultProfile string `json:"default_profile,omitempty"`
+	// Profiles 命名的管线定义集合。
+	Profiles map[string]ProfileConfig `json:"profiles,omitempty"`
+	// Apps AppID 到 Profile 名称的映射。
+	Apps map[string]string `json:"apps,omitempty"`
+	// Locales AppID 到注入语言（zh / en / ja 等）的映射，请求未指定 locale 时使用。
+	Locales map[string]string `json:"locales,omitempty"`
+}
+
+// DefaultPipelineConfig 返回与历史硬编码行为一致的默认管线定义。
+func DefaultPipelineConfig() *PipelineConfig {
+	return &PipelineConfig{
+		Passes: defaultPasses(),
+	}
+}
+
+func defaultPasses() []PassSpec {
+	return []PassSpec{
+		{Name: "HistoryLoader"},
+		{Name: "QueryEmbedding"},
+		// RAG 与记忆检索相互独立，并发执行以降低延迟
+		{Parallel: []PassSpec{
+			{Name: "RAGPass", Params: json.RawMessage(`{"top_k": 3}`)},
+			{Name: "Constitution"},
+		}},
+		// 消息数超过 10 条时触发摘要，保留最近 5 条
+		{Name: "Summarizer", Params: json.RawMessage(`{"model": "deepseek-chat", "max_history": 10, "keep_recent": 5}`)},
+		{Name: "SystemPromptPass"},
+		{Name: "Sanitizer"},
+		// 默认设置 4k 上下文限制
+		{Name: "TokenLimitPass", Params: json.RawMessage(`{"max_tokens": 4000}`)},
+	}
+}
+
+// LoadPipelineConfig 从 JSON 文件读取管线定义并完成校验。
+func LoadPipelineConfig(path string) (*PipelineConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline config %s: %w", path, err)
+	}
+
+	var cfg PipelineConfig
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline config %s: %w", path, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pipeline config %s:\n%w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Validate 检查管线配置的合法性，一次性返回所有发现的问题。
+func (c *PipelineConfig) Validate() error {
+	profiles, err := c.profiles()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range sortedKeys(profiles) {
+		if err := validateProfile(profiles[name]); err != nil {
+			errs = append(errs, fmt.Errorf("profiles.%s: %w", name, err))
+		}
+	}
+
+	if _, ok := profiles[c.defaultProfile()]; !ok {
+		errs = append(errs, fmt.Errorf("default_profile: profile %q is not defined", c.defaultProfile()))
+	}
+	for _, appID := range sortedKeys(c.Apps) {
+		if _, ok := profiles[c.Apps[appID]]; !ok {
+			errs = append(errs, fmt.Errorf("apps.%s: profile %q is not defined", appID, c.Apps[appID]))
+		}
+	}
+	for _, appID := range sortedKeys(c.Locales) {
+		if prompts.NormalizeLocale(c.Locales[appID]) == "" {
+			errs = append(errs, fmt.Errorf("locales.%s: unknown locale %q", appID, c.Locales[appID]))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// profiles 合并兼容写法与 Profiles 字段，返回完整的 Profile 集合。
+func (c *PipelineConfig) profiles() (map[string]ProfileConfig, error) {
+	profiles := make(map[string]ProfileConfig, len(c.Profiles)+1)
+	for name, p := range c.Profiles {
+		profiles[name] = p
+	}
+	if len(c.Passes) > 0 {
+		if _, ok := profiles[DefaultProfileName]; ok {
+			return nil, fmt.Errorf("passes: top-level passes conflict with profiles.%s", DefaultProfileName)
+		}
+		profiles[DefaultProfileName] = ProfileConfig{Passes: c.Passes}
+	}
+	if len(profiles) == 0 {
+		return nil, errors.New("passes: at least one pass is required")
+	}
+	return profiles, nil
+}
+
+func (c *PipelineConfig) defaultProfile() string {
+	if c.DefaultProfile != "" {
+		return c.DefaultProfile
+	}
+	return DefaultProfileName
+}
+
+func validateProfile(p ProfileConfig) error {
+	if len(p.Passes) == 0 {
+		return errors.New("passes: at least one pass is required")
+	}
+
+	var errs []error
+	for i, spec := range p.Passes {
+		if err := validateStepSpec(spec); err != nil {
+			errs = append(errs, fmt.Errorf("passes[%d] (%s): %w", i, spec.label(), err))
+		}
+	}
+	if p.Passes[0].Name != "HistoryLoader" {
+		errs = append(errs, fmt.Errorf("passes[0]: HistoryLoader must be the first pass, got %q", p.Passes[0].Name))
+	}
+	return errors.Join(errs...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func validateStepSpec(spec PassSpec) error {
+	if len(spec.Parallel) == 0 {
+		return validatePassSpec(spec)
+	}
+	if spec.Name != "" || len(spec.Params) > 0 || len(spec.Policy) > 0 || spec.When != nil {
+		return errors.New("parallel groups must not set name, params, policy or when")
+	}
+	if len(spec.Parallel) < 2 {
+		return errors.New("parallel groups require at least two passes")
+	}
+
+	var errs []error
+	for j, child := range spec.Parallel {
+		var err error
+		switch {
+		case len(child.Parallel) > 0:
+			err = errors.New("nested parallel groups are not supported")
+		case child.Name == "HistoryLoader":
+			err = errors.New("HistoryLoader cannot run in a parallel group")
+		default:
+			err = validatePassSpec(child)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parallel[%d] (%s): %w", j, child.label(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validatePassSpec(spec PassSpec) error {
+	if spec.Name == "" {
+		return errors.New("name is required")
+	}
+	def, ok := pipeline.Lookup(spec.Name)
+	if !ok {
+		return fmt.Errorf("unknown pass %q", spec.Name)
+	}
+	_, paramErr := def.ParseConfig(spec.Params)
+	_, policyErr := parsePolicy(spec.Policy, pipeline.Policy{})
+	if policyErr != nil {
+		policyErr = fmt.Errorf("policy: %w", policyErr)
+	}
+	_, whenErr := pipeline.ParseConditions(spec.When)
+	if whenErr != nil {
+		whenErr = fmt.Errorf("when: %w", whenErr)
+	}
+	return errors.Join(paramErr, policyErr, whenErr)
+}
+
+// parsePolicy 在 base 策略的基础上覆盖配置中出现的字段。
+func parsePolicy(raw json.RawMessage, base pipeline.Policy) (pipeline.Policy, error) {
+	if len(raw) == 0 {
+		return base, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&base); err != nil {
+		return base, err
+	}
+	return base, base.Validate()
+}
+
+// buildPipelines 按配置实例化所有 Profile 对应的管线。
+func (c *PipelineConfig) buildPipelines(deps pipeline.Dependencies) (map[string]*pipeline.Pipeline, error) {
+	profiles, err := c.profiles()
+	if err != nil {
+		return nil, err
+	}
+
+	pipelines := make(map[string]*pipeline.Pipeline, len(profiles))
+	for name, profile := range profiles {
+		steps := make([]pipeline.Step, 0, len(profile.Passes))
+		for i, spec := range profile.Passes {
+			if len(spec.Parallel) == 0 {
+				stage, err := buildStage(spec, deps)
+				if err != nil {
+					return nil, fmt.Errorf("profiles.%s: passes[%d] (%s): %w", name, i, spec.Name, err)
+				}
+				steps = append(steps, pipeline.Sequential(stage))
+				continue
+			}
+
+			stages := make([]pipeline.Stage, 0, len(spec.Parallel))
+			for j, child := range spec.Parallel {
+				stage, err := buildStage(child, deps)
+				if err != nil {
+					return nil, fmt.Errorf("profiles.%s: passes[%d].parallel[%d] (%s): %w", name, i, j, child.Name, err)
+				}
+				stages = append(stages, stage)
+			}
+			steps = append(steps, pipeline.Parallel(stages...))
+		}
+		pipelines[name] = pipeline.NewNamedPipeline(name, steps...)
+	}
+	return pipelines, nil
+}
+
+// buildStage 实例化单个 Pass，并在其默认策略与守卫条件之上应用配置覆盖。
+func buildStage(spec PassSpec, deps pipeline.Dependencies) (pipeline.Stage, error) {
+	pass, err := pipeline.BuildPass(spec.Name, spec.Params, deps)
+	if err != nil {
+		return pipeline.Stage{}, err
+	}
+	policy, err := parsePolicy(spec.Policy, pipeline.DefaultPolicyOf(pass))
+	if err != nil {
+		return pipeline.Stage{}, fmt.Errorf("policy: %w", err)
+	}
+	whenExprs := pipeline.DefaultWhenOf(pass)
+	if spec.When != nil {
+		whenExprs = spec.When
+	}
+	when, err := pipeline.ParseConditions(whenExprs)
+	if err != nil {
+		return pipeline.Stage{}, fmt.Errorf("when: %w", err)
+	}
+	return pipeline.Stage{Pass: pass, Policy: policy, When: when}, nil
+}
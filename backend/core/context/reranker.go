@@ -0,0 +1,70 @@
+package context
+
+import (
+	"bytes"
+	stdctx "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// GatewayReranker 通过 LLM 网关的 /v1/rerank（Cohere / Jina 兼容格式）调用交叉编码器为候选文本打分。
+type GatewayReranker struct {
+	llmServiceURL string
+	client        *http.Client
+}
+
+func NewGatewayReranker(llmServiceURL string) *GatewayReranker {
+	return &GatewayReranker{llmServiceURL: llmServiceURL, client: &http.Client{}}
+}
+
+// Rerank 返回与 docs 一一对应的相关性得分，网关未返回全部候选的得分时报错。
+func (r *GatewayReranker) Rerank(ctx stdctx.Context, model, query string, docs []string) ([]float64, error) {
+	url := fmt.Sprintf("%s/v1/rerank", r.llmServiceURL)
+
+	payload := map[string]interface{}{
+		"model":     model,
+		"query":     query,
+		"documents": docs,
+		"top_n":     len(docs),
+	}
+
+	data, _ := json.Marshal(payload)
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("rerank request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Results []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float64 `json:"relevance_score"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	scores := make([]float64, len(docs))
+	seen := make([]bool, len(docs))
+	for _, item := range result.Results {
+		if item.Index < 0 || item.Index >= len(docs) || seen[item.Index] {
+			return nil, fmt.Errorf("rerank returned invalid index %d", item.Index)
+		}
+		scores[item.Index], seen[item.Index] = item.RelevanceScore, true
+	}
+	if len(result.Results) != len(docs) {
+		return nil, fmt.Errorf("expected %d rerank scores, got %d", len(docs), len(result.Results))
+	}
+	return scores, nil
+}
@@ -2,10 +2,12 @@ package context
 
 import (
 	stdctx "context"
+	"context-fabric/backend/core/documents"
 	"context-fabric/backend/core/domain"
 	"context-fabric/backend/core/history"
 	"context-fabric/backend/core/pipeline"
-	"context-fabric/backend/core/pipeline/passes"
+	"context-fabric/backend/core/prompts"
+	"fmt"
 	"log"
 	"time"
 )
@@ -13,114 +15,175 @@ import (
 // Engine 是上下文处理的核心引擎。
 // 它维护了一个 Pipeline 管线，负责将原始会话历史转换为模型可用的优化负载。
 type Engine struct {
-	pipeline      *pipeline.Pipeline
-	llmServiceURL string
+	historySvc     *history.Service
+	pipelines      map[string]*pipeline.Pipeline // Profile 名称 -> 管线
+	apps           map[string]string             // AppID -> Profile 名称
+	defaultProfile string
+	locales        map[string]string // AppID -> 注入语言
+	llmServiceURL  string
+	embedder       pipeline.Embedder // 管线运行时共享的向量计算器，可为空
 }
 
-// NewEngine 初始化引擎并配置默认的处理管线。
-// 默认顺序：1. 加载历史 -> 2. LLM 语义摘要 -> 3. 注入系统提示词 -> 4. Token 限制截断。
-func NewEngine(h *history.Service, llmServiceURL string, m *MemoryService) *Engine {
-	pl := pipeline.NewPipeline(
-		passes.NewHistoryLoader(h),
-		passes.NewRAGPass(),
-		passes.NewConstitutionPass(m),
-		// 消息数超过 10 条时触发摘要，保留最近 5 条
-		passes.NewSummarizerPass(llmServiceURL, "deepseek-chat", 10, 5),
-		passes.NewSystemPromptPass(),
-		passes.NewSanitizePass(m),
-		passes.NewTokenLimitPass(4000), // 默认设置 4k 上下文限制
-	)
-	return &Engine{
-		pipeline:      pl,
-		llmServiceURL: llmServiceURL,
+// NewEngine 根据声明式配置初始化引擎及其处理管线。
+// cfg 为 nil 时使用 DefaultPipelineConfig，即：加载历史 -> RAG -> 记忆注入 -> LLM 语义摘要 -> 注入系统提示词 -> 记忆录入标记 -> Token 限制截断。
+func NewEngine(h *history.Service, llmServiceURL string, m *MemoryService, ps *prompts.Service, idx *documents.Index, cfg *PipelineConfig) (*Engine, error) {
+	if cfg == nil {
+		cfg = DefaultPipelineConfig()
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pipeline config:\n%w", err)
+	}
+	// 仅在服务非空时注入，避免 typed nil 绕过各 Pass 工厂中的空值检查
+	deps := pipeline.Dependencies{LLMServiceURL: llmServiceURL, Reranker: NewGatewayReranker(llmServiceURL)}
+	if h != nil {
+		deps.History = h
+	}
+	if m != nil {
+		deps.Memory = m
+	}
+	if ps != nil {
+		deps.Prompts = ps
+	}
+	if idx != nil {
+		deps.Documents = idx
+	}
+	pls, err := cfg.buildPipelines(deps)
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		historySvc:     h,
+		pipelines:      pls,
+		apps:           cfg.Apps,
+		defaultProfile: cfg.defaultProfile(),
+		locales:        cfg.Locales,
+		llmServiceURL:  llmServiceURL,
+	}
+	if m != nil {
+		e.embedder = m
+	}
+	return e, nil
 }
 
-// BuildPayload 驱动管线执行，并负责将管线生成的内部 Trace 信息归一化为业务层可理解的格式。
-func (e *Engine) BuildPayload(ctx stdctx.Context, id string, query string, modelID string, ragEnabled bool, ragEmbeddingModel string, sanitizationModel string) ([]domain.Message, error) {
-	log.Printf("[Core] Pipeline Start - Session: %s, Query: %s, RAG: %v", id, query, ragEnabled)
-	start := time.Now()
+// selectPipeline 根据会话所属的 AppID 选择 Profile，未配置映射时回退到默认 Profile。同时返回会话的 AppID。
+func (e *Engine) selectPipeline(ctx stdctx.Context, sessionID string) (*pipeline.Pipeline, string) {
+	if sess, err := e.historySvc.Get(ctx, sessionID); err == nil {
+		if name, ok := e.apps[sess.AppID]; ok {
+			return e.pipelines[name], sess.AppID
+		}
+		return e.pipelines[e.defaultProfile], sess.AppID
+	}
+	return e.pipelines[e.defaultProfile], ""
+}
+
+// localeFor 返回注入模板的语言：请求指定的 locale 优先，其次为 AppID 配置的语言，最后为默认语言。
+func (e *Engine) localeFor(requested, appID string) string {
+	if locale := prompts.NormalizeLocale(requested); locale != "" {
+		return locale
+	}
+	if locale := prompts.NormalizeLocale(e.locales[appID]); locale != "" {
+		return locale
+	}
+	return prompts.DefaultLocale
+}
+
+// BuildRequest 描述一次上下文构建请求。
+type BuildRequest struct {
+	SessionID         string            `json:"session_id"`
+	Query             string            `json:"query"`
+	ModelID           string            `json:"model_id"`
+	RAGEnabled        bool              `json:"rag_enabled"`
+	RAGEmbeddingModel string            `json:"rag_embedding_model"`
+	SanitizationModel string            `json:"sanitization_model_id"`
+	SummaryModel      string            `json:"summary_model_id"` // 可选，留空时 Summarizer 使用 model_id
+	Timezone          string            `json:"timezone"`         // 可选，IANA 时区名，用于系统提示词中的时间变量
+	UserProfile       map[string]string `json:"user_profile"`     // 可选，供系统提示词模板引用的用户画像字段
+	Locale            string            `json:"locale"`           // 可选，注入模板的语言（zh / en / ja），留空时按 AppID 配置
+
+	RAGCollections []domain.RAGCollection `json:"rag_collections"` // 可选，本次检索的知识库集合，覆盖 Profile 配置
+}
+
+// run 初始化黑板数据并执行所选 Profile 的管线。pending 为尚未持久化、需追加在历史之后的消息；
+// 携带 pending 即为 dry-run，Pass 不会写入摘要等持久化状态。
+func (e *Engine) run(ctx stdctx.Context, req BuildRequest, pending []domain.Message) (*pipeline.ContextData, error) {
+	id := req.SessionID
+	log.Printf("[Core] Pipeline Start - Session: %s, Query: %s, RAG: %v", id, req.Query, req.RAGEnabled)
 
 	// 1. 初始化管线运行时的黑板数据 (ContextData)
 	data := &pipeline.ContextData{
 		SessionID: id,
 		Messages:  make([]domain.Message, 0),
+		Pending:   pending,
+		DryRun:    pending != nil,
 		Meta:      make(map[string]interface{}),
-		Traces:    make([]map[string]interface{}, 0),
+		Trace:     pipeline.NewTrace("Pipeline"),
+		Embedder:  e.embedder,
 	}
 
 	// 注入初始上下文元数据
-	data.Meta["query"] = query
-	data.Meta["model_id"] = modelID
-	data.Meta["rag_enabled"] = ragEnabled
-	data.Meta["rag_embedding_model"] = ragEmbeddingModel
+	data.Meta["query"] = req.Query
+	data.Meta["model_id"] = req.ModelID
+	data.Meta["rag_enabled"] = req.RAGEnabled
+	data.Meta["rag_embedding_model"] = req.RAGEmbeddingModel
 	// 将前端传递的清洗模型 ID 存入元数据，以便在 AppendMessage 时取出使用
-	data.Meta["sanitization_model_id"] = sanitizationModel
+	data.Meta["sanitization_model_id"] = req.SanitizationModel
+	if req.SummaryModel != "" {
+		data.Meta["summary_model_id"] = req.SummaryModel
+	}
+	if req.Timezone != "" {
+		data.Meta["timezone"] = req.Timezone
+	}
+	if len(req.RAGCollections) > 0 {
+		data.Meta["rag_collections"] = req.RAGCollections
+	}
+	if len(req.UserProfile) > 0 {
+		data.Meta["user_profile"] = req.UserProfile
+	}
 
-	// 2. 启动 Pipeline 逻辑处理
-	if err := e.pipeline.Execute(ctx, data); err != nil {
+	// 2. 按 AppID 选择 Profile 并启动 Pipeline 逻辑处理
+	pl, appID := e.selectPipeline(ctx, id)
+	data.Meta["pipeline_profile"] = pl.Name()
+	data.Meta["locale"] = e.localeFor(req.Locale, appID)
+	log.Printf("[Core] Pipeline Profile - Session: %s, Profile: %s", id, pl.Name())
+	if err := pl.Execute(ctx, data); err != nil {
 		log.Printf("[Core] Pipeline Failed - Session: %s, Error: %v", id, err)
 		return nil, err
 	}
+	return data, nil
+}
+
+// transientMeta 是只供本次管线运行使用的请求输入，不随最后一条消息返回与持久化（如用户画像等个人信息）。
+var transientMeta = map[string]bool{
+	"user_profile":    true,
+	"timezone":        true,
+	"rag_collections": true,
+}
+
+// BuildPayload 驱动管线执行，并负责将管线生成的内部 Trace 信息归一化为业务层可理解的格式。
+func (e *Engine) BuildPayload(ctx stdctx.Context, req BuildRequest) ([]domain.Message, error) {
+	id := req.SessionID
+	start := time.Now()
+	data, err := e.run(ctx, req, nil)
+	if err != nil {
+		return nil, err
+	}
 
 	// 3. 处理 Trace 和 Meta
-	// 将 Pipeline 中收集的 Trace 信息转换为 domain.TraceEvent，并附着到最后一条消息上
+	// 将 Pipeline 中收集的 Span 转换为 domain.TraceEvent，并附着到最后一条消息上
 	if len(data.Messages) > 0 {
 		lastMsg := &data.Messages[len(data.Messages)-1]
 
-		var domainTraces []domain.TraceEvent
-		var internalDetails []map[string]interface{}
-		baseTime := time.Now()
-
-		for _, t := range data.Traces {
-			src, _ := t["source"].(string)
-			act, _ := t["action"].(string)
-			dat, _ := t["data"].(map[string]interface{})
-			if dat == nil {
-				dat = make(map[string]interface{})
-			}
-
-			// 3.1 过滤掉冗余的管线级元数据，避免时序图过度拥挤
-			if (src == "Core" || src == "Pipeline") && (act == "Start" || act == "Finished") {
-				continue
-			}
-			if act == "Loaded" { // HistoryLoader 的内部事件
-				continue
-			}
-
-			// 3.2 如果是 Pipeline 的 Complete 事件（即一个 Pass 执行完成）
-			if src == "Pipeline" && act == "Complete" {
-				// 将之前累积的内部细节注入到这个主 Trace 中，实现交互图节点的“折叠”
-				if len(internalDetails) > 0 {
-					dat["internal_logs"] = internalDetails
-					internalDetails = nil // 重置缓冲区
-				}
-
-				domainTraces = append(domainTraces, domain.TraceEvent{
-					Source:    "Core",
-					Target:    "Core",
-					Action:    act,
-					Data:      dat,
-					Timestamp: baseTime.Add(time.Duration(len(domainTraces)) * time.Microsecond),
-				})
-				continue
-			}
-
-			// 3.3 其他内部业务事件（如 Summarizer 的 Summarized/SummarizeError, TokenLimit 的 Truncate）
-			// 暂时缓存起来，等待下一个 Complete 事件将其打包。
-			dat["internal_action"] = act
-			dat["internal_component"] = src
-			internalDetails = append(internalDetails, dat)
-		}
-
-		lastMsg.Traces = append(lastMsg.Traces, domainTraces...)
+		// Pass Span 折叠为时序图节点，内部事件作为 internal_logs 附着
+		lastMsg.Traces = append(lastMsg.Traces, data.Trace.DomainEvents()...)
 
 		// 合并 Meta
 		if lastMsg.Meta == nil {
 			lastMsg.Meta = make(map[string]interface{})
 		}
 		for k, v := range data.Meta {
-			lastMsg.Meta[k] = v
+			if !transientMeta[k] {
+				lastMsg.Meta[k] = v
+			}
 		}
 	}
 
@@ -194,17 +257,30 @@ func (s *Service) AppendMessage(ctx stdctx.Context, id string, msg domain.Messag
 // GetOptimizedContext 是核心业务入口。
 // 它负责记录用户请求并驱动 Engine 生成优化后的模型上下文。
 func (s *Service) GetOptimizedContext(ctx stdctx.Context, id, query string, modelID string, ragEnabled bool, ragEmbeddingModel string, sanitizationModel string) ([]domain.Message, error) {
+	return s.BuildContext(ctx, BuildRequest{
+		SessionID:         id,
+		Query:             query,
+		ModelID:           modelID,
+		RAGEnabled:        ragEnabled,
+		RAGEmbeddingModel: ragEmbeddingModel,
+		SanitizationModel: sanitizationModel,
+	})
+}
+
+// BuildContext 与 GetOptimizedContext 相同，但接收完整的构建请求（如指定摘要模型）。
+func (s *Service) BuildContext(ctx stdctx.Context, req BuildRequest) ([]domain.Message, error) {
+	id := req.SessionID
 	log.Printf("[Core] GetContext Request - Session: %s", id)
 
 	// 1. 自动确保 Session 环境存在
 	s.historySvc.GetOrCreateSession(ctx, id, "auto")
 
 	// 2. 将当前用户提问持久化到历史库中
-	userMsg := domain.Message{Role: domain.RoleUser, Content: query, Timestamp: time.Now()}
+	userMsg := domain.Message{Role: domain.RoleUser, Content: req.Query, Timestamp: time.Now()}
 	s.historySvc.Append(ctx, id, userMsg)
 
 	// 3. 调用核心引擎通过 Pipeline 构建优化后的消息 Payload
-	payload, err := s.engine.BuildPayload(ctx, id, query, modelID, ragEnabled, ragEmbeddingModel, sanitizationModel)
+	payload, err := s.engine.BuildPayload(ctx, req)
 
 	// 4. 将处理后的元数据（如 Token 统计）同步更新到持久化库的消息 Meta 中
 	if err == nil && len(payload) > 0 {
@@ -215,3 +291,10 @@ func (s *Service) GetOptimizedContext(ctx stdctx.Context, id, query string, mode
 
 	return payload, err
 }
+
+// Explain 以 dry-run 方式运行管线：用户提问只追加在内存中，不写入历史，
+// 返回最终负载以及逐 Pass 的 Token 变化、被丢弃的消息和注入的记忆 / RAG 片段。
+func (s *Service) Explain(ctx stdctx.Context, req BuildRequest) (*Explanation, error) {
+	log.Printf("[Core] Explain Request - Session: %s", req.SessionID)
+	return s.engine.Explain(ctx, req)
+}
@@ -0,0 +1,199 @@
+package documents
+
+import (
+	"context-fabric/backend/core/domain"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25 参数。
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Index 是按集合划分的内存 BM25 倒排索引，覆盖通过本服务导入的全部分块，
+// 用于补足向量检索难以命中的精确标识符、错误码与产品名。
+type Index struct {
+	mu          sync.RWMutex
+	collections map[string]*lexicon
+}
+
+// lexicon 是单个集合的倒排索引。
+type lexicon struct {
+	chunks   map[string]*indexedChunk  // 点 ID -> 分块
+	docs     map[string][]string       // 文档 ID -> 点 ID
+	postings map[string]map[string]int // 词项 -> 点 ID -> 词频
+	totalLen int
+}
+
+type indexedChunk struct {
+	chunk  domain.RetrievedChunk
+	length int
+	terms  map[string]int
+}
+
+func NewIndex() *Index {
+	return &Index{collections: make(map[string]*lexicon)}
+}
+
+// Add 索引文档的全部分块，同 ID 的旧文档（可能位于其他集合）先被移除。
+func (x *Index) Add(doc *domain.Document) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(doc.ID)
+
+	lx := x.collections[doc.Collection]
+	if lx == nil {
+		lx = &lexicon{
+			chunks:   make(map[string]*indexedChunk),
+			docs:     make(map[string][]string),
+			postings: make(map[string]map[string]int),
+		}
+		x.collections[doc.Collection] = lx
+	}
+	for _, c := range doc.Chunks {
+		id := chunkPointID(doc.ID, c.Index)
+		text := c.Text()
+		ic := &indexedChunk{
+			chunk: domain.RetrievedChunk{ID: id, DocID: doc.ID, Title: doc.Title, Source: doc.Source, ChunkIndex: c.Index, Content: text},
+			terms: make(map[string]int),
+		}
+		for _, t := range Terms(text) {
+			ic.terms[t]++
+			ic.length++
+		}
+		for t, tf := range ic.terms {
+			if lx.postings[t] == nil {
+				lx.postings[t] = make(map[string]int)
+			}
+			lx.postings[t][id] = tf
+		}
+		lx.chunks[id] = ic
+		lx.docs[doc.ID] = append(lx.docs[doc.ID], id)
+		lx.totalLen += ic.length
+	}
+}
+
+// Remove 从索引中移除文档的全部分块。
+func (x *Index) Remove(docID string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(docID)
+}
+
+func (x *Index) removeLocked(docID string) {
+	for _, lx := range x.collections {
+		for _, id := range lx.docs[docID] {
+			ic := lx.chunks[id]
+			for t := range ic.terms {
+				delete(lx.postings[t], id)
+				if len(lx.postings[t]) == 0 {
+					delete(lx.postings, t)
+				}
+			}
+			lx.totalLen -= ic.length
+			delete(lx.chunks, id)
+		}
+		delete(lx.docs, docID)
+	}
+}
+
+// Search 返回集合内与查询 BM25 得分最高的至多 limit 个分块，结果的 LexicalScore / LexicalRank 已填充。
+func (x *Index) Search(collection, query string, limit int) []domain.RetrievedChunk {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	lx := x.collections[collection]
+	if lx == nil || len(lx.chunks) == 0 || limit <= 0 {
+		return nil
+	}
+
+	n := float64(len(lx.chunks))
+	avgLen := float64(lx.totalLen) / n
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, t := range Terms(query) {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		postings := lx.postings[t]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id, tf := range postings {
+			norm := 1 - bm25B + bm25B*float64(lx.chunks[id].length)/avgLen
+			scores[id] += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*norm)
+		}
+	}
+
+	hits := make([]domain.RetrievedChunk, 0, len(scores))
+	for id, score := range scores {
+		hit := lx.chunks[id].chunk
+		hit.Score, hit.LexicalScore = score, score
+		hits = append(hits, hit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	for i := range hits {
+		hits[i].LexicalRank = i + 1
+	}
+	return hits
+}
+
+// Terms 将文本切分为检索词项并统一小写：字母数字按单词切分，以 _ - . 相连的标识符（如 ERR_CONN_RESET、v1.2.3）
+// 整体作为一个词项并额外拆出其组成部分；中日韩文本按相邻二字组切分，单字成段时保留单字。
+func Terms(text string) []string {
+	var terms []string
+	runes := []rune(strings.ToLower(text))
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case isCJK(r):
+			j := i
+			for j < len(runes) && isCJK(runes[j]) {
+				j++
+			}
+			if j-i == 1 {
+				terms = append(terms, string(r))
+			}
+			for k := i; k+1 < j; k++ {
+				terms = append(terms, string(runes[k:k+2]))
+			}
+			i = j
+		case isWordRune(r):
+			j := i
+			for j < len(runes) && (isWordRune(runes[j]) || (isJoiner(runes[j]) && j+1 < len(runes) && isWordRune(runes[j+1]))) {
+				j++
+			}
+			word := string(runes[i:j])
+			terms = append(terms, word)
+			if parts := strings.FieldsFunc(word, isJoiner); len(parts) > 1 {
+				terms = append(terms, parts...)
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	return terms
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func isJoiner(r rune) bool {
+	return r == '_' || r == '-' || r == '.'
+}
@@ -0,0 +1,120 @@
+package documents
+
+import (
+	"context-fabric/backend/core/domain"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, World", []string{"hello", "world"}},
+		{"ERR_CONN_RESET on v1.2.3", []string{"err_conn_reset", "err", "conn", "reset", "on", "v1.2.3", "v1", "2", "3"}},
+		{"end.", []string{"end"}},
+		{"上下文窗口", []string{"上下", "下文", "文窗", "窗口"}},
+		{"用 Go 写", []string{"用", "go", "写"}},
+	}
+	for _, tt := range tests {
+		if got := Terms(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Terms(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func indexDoc(x *Index, id, collection string, chunks ...string) {
+	doc := &domain.Document{ID: id, Title: id, Collection: collection}
+	for i, c := range chunks {
+		doc.Chunks = append(doc.Chunks, domain.DocumentChunk{Index: i, Content: c})
+	}
+	x.Add(doc)
+}
+
+func TestIndexSearchScoring(t *testing.T) {
+	x := NewIndex()
+	indexDoc(x, "a", "kb",
+		"restart the gateway service",                             // 0
+		"ERR_CONN_RESET means the gateway dropped the connection", // 1
+		"gateway gateway gateway timeout tuning",                  // 2
+		"unrelated text about billing",                            // 3
+	)
+
+	ids := func(hits []domain.RetrievedChunk) []int {
+		out := make([]int, len(hits))
+		for i, h := range hits {
+			out[i] = h.ChunkIndex
+		}
+		return out
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []int
+	}{
+		// 词频更高的分块得分更高
+		{"term frequency", "gateway", 10, []int{2, 0, 1}},
+		// 罕见词项的 IDF 高于常见词项
+		{"idf", "gateway connection", 10, []int{1, 2, 0}},
+		{"identifier", "err_conn_reset", 10, []int{1}},
+		{"identifier part", "reset", 10, []int{1}},
+		{"limit", "gateway", 2, []int{2, 0}},
+		{"no match", "kubernetes", 10, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits := x.Search("kb", tt.query, tt.limit)
+			if got := ids(hits); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+			for i, h := range hits {
+				if h.LexicalRank != i+1 || h.Score != h.LexicalScore || h.Score <= 0 {
+					t.Errorf("hit %d: rank %d score %v lexical %v", i, h.LexicalRank, h.Score, h.LexicalScore)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexSearchMatchesBM25(t *testing.T) {
+	x := NewIndex()
+	indexDoc(x, "a", "kb", "alpha beta", "beta gamma gamma")
+
+	// 两个分块共 5 个词项，平均长度 2.5；alpha 只出现在长度为 2 的分块中一次
+	n, df, tf, length, avg := 2.0, 1.0, 1.0, 2.0, 2.5
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	want := idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*length/avg))
+
+	hits := x.Search("kb", "alpha", 10)
+	if len(hits) != 1 || math.Abs(hits[0].Score-want) > 1e-9 {
+		t.Fatalf("Search(alpha) = %+v, want one hit scoring %v", hits, want)
+	}
+}
+
+func TestIndexCollectionsAndReplace(t *testing.T) {
+	x := NewIndex()
+	indexDoc(x, "a", "kb1", "shared keyword here")
+	indexDoc(x, "b", "kb2", "shared keyword there")
+
+	if hits := x.Search("kb1", "keyword", 10); len(hits) != 1 || hits[0].DocID != "a" {
+		t.Fatalf("kb1 hits = %+v, want only doc a", hits)
+	}
+
+	// 同 ID 文档重新导入到其他集合时，旧集合中的分块被移除
+	indexDoc(x, "a", "kb2", "moved keyword")
+	if hits := x.Search("kb1", "keyword", 10); len(hits) != 0 {
+		t.Errorf("kb1 still has %d hits after the document moved", len(hits))
+	}
+	if hits := x.Search("kb2", "keyword", 10); len(hits) != 2 {
+		t.Errorf("kb2 hits = %d, want 2", len(hits))
+	}
+
+	x.Remove("b")
+	if hits := x.Search("kb2", "there", 10); len(hits) != 0 {
+		t.Errorf("removed document is still searchable: %+v", hits)
+	}
+}
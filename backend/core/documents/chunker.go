@@ -0,0 +1,353 @@
+package documents
+
+import (
+	"context-fabric/backend/core/domain"
+	"context-fabric/backend/core/tokens"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// 文档格式。
+const (
+	FormatMarkdown = "markdown"
+	FormatText     = "text"
+)
+
+// maxChunkSize 是单个分块的上限，避免超出常见向量模型的输入长度。
+const maxChunkSize = 8192
+
+// chunkCounter 返回分块使用的计数器，测试中替换为估算口径。
+var chunkCounter = tokens.Default
+
+// ChunkOptions 控制分块大小与相邻分块的重叠，单位为 Token（默认计数口径）。
+type ChunkOptions struct {
+	Size    int `json:"chunk_size"`
+	Overlap int `json:"chunk_overlap"`
+}
+
+// DefaultChunkOptions 是未指定分块参数时的默认值。
+var DefaultChunkOptions = ChunkOptions{Size: 512, Overlap: 64}
+
+func (o ChunkOptions) Validate() error {
+	var errs []error
+	if o.Size <= 0 || o.Size > maxChunkSize {
+		errs = append(errs, fmt.Errorf("chunk_size must be in (0, %d], got %d", maxChunkSize, o.Size))
+	}
+	if o.Overlap < 0 || o.Overlap >= o.Size {
+		errs = append(errs, fmt.Errorf("chunk_overlap must be in [0, chunk_size), got %d", o.Overlap))
+	}
+	return errors.Join(errs...)
+}
+
+// DetectFormat 按文件扩展名判断格式，.md / .markdown / .mdx 视为 Markdown，其余按纯文本处理。
+func DetectFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".md", ".markdown", ".mdx":
+		return FormatMarkdown
+	}
+	return FormatText
+}
+
+// Split 将文档切分为分块。Markdown 先按标题切成章节，分块不跨章节并记录标题路径；
+// 章节内按段落聚合到 Size 以内，超长段落依次按行、句子、字符切开；相邻分块重叠约 Overlap 个 Token。
+func Split(text, format string, opts ChunkOptions) []domain.DocumentChunk {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	c := &chunker{counter: chunkCounter(), opts: opts}
+
+	var chunks []domain.DocumentChunk
+	for _, sec := range splitSections(text, format == FormatMarkdown) {
+		budget := opts.Size
+		if sec.heading != "" {
+			// 标题路径随分块一起向量化，从预算中扣除，但至少保留一半给正文
+			budget = max(opts.Size-c.counter.Count(sec.heading)-1, opts.Size/2)
+		}
+		for _, content := range c.pack(paragraphs(sec.body), budget) {
+			chunk := domain.DocumentChunk{Index: len(chunks), Heading: sec.heading, Content: content}
+			chunk.Tokens = c.counter.Count(chunk.Text())
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+// section 是 Markdown 的一个章节，heading 为从一级标题起的标题路径。
+type section struct {
+	heading string
+	body    string
+}
+
+// splitSections 按 Markdown 标题切分章节，围栏代码块内的 # 不视为标题；只有标题没有正文的章节被跳过。
+func splitSections(text string, markdown bool) []section {
+	if !markdown {
+		return []section{{body: text}}
+	}
+	var secs []section
+	var path [6]string
+	heading := ""
+	var body strings.Builder
+	flush := func() {
+		if strings.TrimSpace(body.String()) != "" {
+			secs = append(secs, section{heading: heading, body: body.String()})
+		}
+		body.Reset()
+	}
+
+	inFence := false
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if isFence(line) {
+			inFence = !inFence
+		}
+		if level, title := parseHeading(line); !inFence && level > 0 {
+			flush()
+			path[level-1] = title
+			for i := level; i < len(path); i++ {
+				path[i] = ""
+			}
+			var parts []string
+			for _, p := range path {
+				if p != "" {
+					parts = append(parts, p)
+				}
+			}
+			heading = strings.Join(parts, " > ")
+			continue
+		}
+		body.WriteString(line)
+	}
+	flush()
+	return secs
+}
+
+// parseHeading 解析 ATX 标题行（# 标题），返回级别与标题文本，非标题行返回 0。
+func parseHeading(line string) (int, string) {
+	trimmed := strings.TrimRight(line, "\n")
+	level := 0
+	for level < len(trimmed) && trimmed[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(trimmed) && trimmed[level] != ' ' && trimmed[level] != '\t') {
+		return 0, ""
+	}
+	title := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(trimmed[level:]), "#"))
+	if title == "" {
+		return 0, ""
+	}
+	return level, title
+}
+
+func isFence(line string) bool {
+	t := strings.TrimSpace(line)
+	return strings.HasPrefix(t, "```") || strings.HasPrefix(t, "~~~")
+}
+
+// unit 是参与聚合的最小文本单元，sep 为与前一个单元拼接时使用的分隔符。
+type unit struct {
+	text string
+	sep  string
+}
+
+// paragraphs 按空行切分段落，围栏代码块内的空行不切分。
+func paragraphs(body string) []unit {
+	var units []unit
+	var cur strings.Builder
+	flush := func() {
+		if t := strings.TrimSpace(cur.String()); t != "" {
+			units = append(units, unit{text: t, sep: "\n\n"})
+		}
+		cur.Reset()
+	}
+	inFence := false
+	for _, line := range strings.SplitAfter(body, "\n") {
+		if isFence(line) {
+			inFence = !inFence
+		}
+		if !inFence && strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		cur.WriteString(line)
+	}
+	flush()
+	return units
+}
+
+type chunker struct {
+	counter *tokens.Counter
+	opts    ChunkOptions
+}
+
+// pack 将单元聚合为不超过 budget 个 Token 的分块，新分块以上一分块末尾约 Overlap 个 Token 开头。
+func (c *chunker) pack(units []unit, budget int) []string {
+	var expanded []unit
+	for _, u := range units {
+		expanded = append(expanded, c.splitLong(u, budget)...)
+	}
+
+	var chunks []string
+	var cur []unit
+	curTokens := 0
+	for _, u := range expanded {
+		n := c.counter.Count(u.text)
+		if len(cur) > 0 && curTokens+n > budget {
+			chunks = append(chunks, join(cur))
+			cur = c.overlap(cur)
+			curTokens = c.counter.Count(join(cur))
+			if curTokens+n > budget {
+				cur, curTokens = nil, 0
+			}
+		}
+		cur = append(cur, u)
+		curTokens += n
+		if len(cur) > 1 {
+			curTokens++ // 分隔符
+		}
+	}
+	if len(cur) > 0 {
+		chunks = append(chunks, join(cur))
+	}
+	return chunks
+}
+
+// overlap 返回上一分块末尾不超过 Overlap 个 Token 的单元；最后一个单元本身过长时截取其末尾。
+func (c *chunker) overlap(prev []unit) []unit {
+	if c.opts.Overlap <= 0 {
+		return nil
+	}
+	total := 0
+	start := len(prev)
+	for start > 0 {
+		n := c.counter.Count(prev[start-1].text)
+		if total+n > c.opts.Overlap {
+			break
+		}
+		total += n
+		start--
+	}
+	if start < len(prev) {
+		return append([]unit(nil), prev[start:]...)
+	}
+	last := []rune(prev[len(prev)-1].text)
+	tail := wordStart(last, len(last)-c.fitSuffix(last, c.opts.Overlap))
+	if tail >= len(last) {
+		return nil
+	}
+	return []unit{{text: strings.TrimSpace(string(last[tail:]))}}
+}
+
+// splitLong 将超出 budget 的单元依次按行、句子与字符切开，切出的单元保持原有的拼接方式。
+func (c *chunker) splitLong(u unit, budget int) []unit {
+	if c.counter.Count(u.text) <= budget {
+		return []unit{u}
+	}
+	var out []unit
+	lines := strings.Split(u.text, "\n")
+	for i, line := range lines {
+		sep := "\n"
+		if i == 0 {
+			sep = u.sep
+		}
+		if c.counter.Count(line) <= budget {
+			out = append(out, unit{text: line, sep: sep})
+			continue
+		}
+		for j, s := range sentences(line) {
+			if j > 0 {
+				sep = ""
+			}
+			if c.counter.Count(s) <= budget {
+				out = append(out, unit{text: s, sep: sep})
+				continue
+			}
+			runes := []rune(s)
+			for len(runes) > 0 {
+				n := max(c.fitPrefix(runes, budget), 1)
+				out = append(out, unit{text: string(runes[:n]), sep: sep})
+				runes, sep = runes[n:], ""
+			}
+		}
+	}
+	return out
+}
+
+// sentences 在句末标点（及其后的空白）之后切分，切分结果拼接后与原文一致。
+func sentences(text string) []string {
+	var out []string
+	runes := []rune(text)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if !strings.ContainsRune("。！？!?；;.", runes[i]) {
+			continue
+		}
+		// 英文标点后需跟空白才算句末，避免切开小数、网址与缩写
+		if runes[i] < 0x80 && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && (strings.ContainsRune("。！？!?；;.\"'”’)）", runes[j]) || unicode.IsSpace(runes[j])) {
+			j++
+		}
+		out = append(out, string(runes[start:j]))
+		start, i = j, j-1
+	}
+	if start < len(runes) {
+		out = append(out, string(runes[start:]))
+	}
+	return out
+}
+
+// fitPrefix 二分查找 Token 数不超过 budget 的最长前缀（rune 数）。
+func (c *chunker) fitPrefix(runes []rune, budget int) int {
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if c.counter.Count(string(runes[:mid])) <= budget {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+// fitSuffix 二分查找 Token 数不超过 budget 的最长后缀（rune 数）。
+func (c *chunker) fitSuffix(runes []rune, budget int) int {
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if c.counter.Count(string(runes[len(runes)-mid:])) <= budget {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+// wordStart 将截取起点后移到下一个词的开头，避免重叠部分以半个英文单词开始。
+func wordStart(runes []rune, i int) int {
+	if i <= 0 || i >= len(runes) || !isWordRune(runes[i-1]) || !isWordRune(runes[i]) {
+		return i
+	}
+	for i < len(runes) && isWordRune(runes[i]) {
+		i++
+	}
+	return i
+}
+
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r)) && !unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func join(units []unit) string {
+	var sb strings.Builder
+	for i, u := range units {
+		if i > 0 {
+			sb.WriteString(u.sep)
+		}
+		sb.WriteString(u.text)
+	}
+	return strings.TrimSpace(sb.String())
+}
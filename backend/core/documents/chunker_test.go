@@ -0,0 +1,103 @@
+package documents
+
+import (
+	"context-fabric/backend/core/tokens"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// 使用估算口径，测试结果不依赖分词器的下载。
+func TestMain(m *testing.M) {
+	chunkCounter = func() *tokens.Counter { return &tokens.Counter{} }
+	os.Exit(m.Run())
+}
+
+func TestChunkOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ChunkOptions
+		wantErr bool
+	}{
+		{"default", DefaultChunkOptions, false},
+		{"no overlap", ChunkOptions{Size: 100}, false},
+		{"overlap equals size", ChunkOptions{Size: 100, Overlap: 100}, true},
+		{"overlap exceeds size", ChunkOptions{Size: 100, Overlap: 150}, true},
+		{"negative overlap", ChunkOptions{Size: 100, Overlap: -1}, true},
+		{"zero size", ChunkOptions{}, true},
+		{"size above limit", ChunkOptions{Size: maxChunkSize + 1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSplitExactSize(t *testing.T) {
+	text := strings.TrimSpace(strings.Repeat("alpha beta gamma delta. ", 20))
+	size := chunkCounter().Count(text)
+
+	chunks := Split(text, FormatText, ChunkOptions{Size: size})
+	if len(chunks) != 1 || chunks[0].Content != text {
+		t.Fatalf("input of exactly chunk_size produced %d chunks, want the input as one chunk", len(chunks))
+	}
+	if chunks[0].Tokens != size {
+		t.Errorf("Tokens = %d, want %d", chunks[0].Tokens, size)
+	}
+	if chunks := Split(text, FormatText, ChunkOptions{Size: size - 1}); len(chunks) < 2 {
+		t.Errorf("input one token over chunk_size produced %d chunks, want at least 2", len(chunks))
+	}
+}
+
+func TestSplitMultibyte(t *testing.T) {
+	var paras []string
+	for i := 0; i < 12; i++ {
+		paras = append(paras, strings.Repeat("上下文工程需要在有限的窗口内安排信息。", 4))
+	}
+	text := strings.Join(paras, "\n\n") + "\n\n" + strings.Repeat("无标点的超长中文段落", 60)
+	opts := ChunkOptions{Size: 80, Overlap: 16}
+
+	chunks := Split(text, FormatText, opts)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want several", len(chunks))
+	}
+	counter := chunkCounter()
+	for i, c := range chunks {
+		if c.Index != i {
+			t.Errorf("chunk %d has Index %d", i, c.Index)
+		}
+		if !utf8.ValidString(c.Content) {
+			t.Errorf("chunk %d is not valid UTF-8: %q", i, c.Content)
+		}
+		if n := counter.Count(c.Content); n > opts.Size {
+			t.Errorf("chunk %d has %d tokens, want <= %d", i, n, opts.Size)
+		}
+	}
+	if last := chunks[len(chunks)-1].Content; !strings.HasSuffix(last, "无标点的超长中文段落") {
+		t.Errorf("tail of the document was lost: %q", last)
+	}
+}
+
+func TestSplitOverlap(t *testing.T) {
+	var paras []string
+	for i := 0; i < 10; i++ {
+		paras = append(paras, strings.Repeat(string(rune('a'+i))+"word ", 10))
+	}
+	text := strings.Join(paras, "\n\n")
+	size := chunkCounter().Count(paras[0]) * 3
+
+	plain := Split(text, FormatText, ChunkOptions{Size: size})
+	overlapped := Split(text, FormatText, ChunkOptions{Size: size, Overlap: size / 3})
+	if len(overlapped) <= len(plain) {
+		t.Errorf("overlap produced %d chunks, want more than %d", len(overlapped), len(plain))
+	}
+	for i := 1; i < len(overlapped); i++ {
+		prev, cur := overlapped[i-1].Content, overlapped[i].Content
+		first := strings.SplitN(cur, "\n\n", 2)[0]
+		if !strings.HasSuffix(prev, first) {
+			t.Errorf("chunk %d does not start with the tail of chunk %d: %q / %q", i, i-1, first, prev)
+		}
+	}
+}
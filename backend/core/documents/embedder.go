@@ -0,0 +1,87 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// embedBatchSize 是单次请求 /v1/embeddings 的最大文本数。
+const embedBatchSize = 32
+
+// GatewayEmbedder 通过 LLM 网关的 /v1/embeddings 批量获取向量。
+type GatewayEmbedder struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewGatewayEmbedder(llmServiceURL string) *GatewayEmbedder {
+	return &GatewayEmbedder{baseURL: llmServiceURL, client: &http.Client{}}
+}
+
+// Embed 按输入顺序返回每段文本的向量，网关返回的数量不符时报错。
+func (e *GatewayEmbedder) Embed(ctx context.Context, texts []string, modelID string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		batch := texts[start:min(start+embedBatchSize, len(texts))]
+		out, err := e.embedBatch(ctx, batch, modelID)
+		if err != nil {
+			return nil, fmt.Errorf("embed texts [%d, %d): %w", start, start+len(batch), err)
+		}
+		vectors = append(vectors, out...)
+	}
+	return vectors, nil
+}
+
+func (e *GatewayEmbedder) embedBatch(ctx context.Context, texts []string, modelID string) ([][]float32, error) {
+	url := fmt.Sprintf("%s/v1/embeddings", e.baseURL)
+
+	payload := map[string]interface{}{
+		"model": modelID,
+		"input": texts,
+	}
+
+	data, _ := json.Marshal(payload)
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("embedding request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(result.Data))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for i, d := range result.Data {
+		idx := d.Index
+		if idx < 0 || idx >= len(texts) || vectors[idx] != nil {
+			idx = i // 网关未返回有效的 index 时按顺序对应
+		}
+		if len(d.Embedding) == 0 {
+			return nil, fmt.Errorf("empty embedding for input %d", idx)
+		}
+		vectors[idx] = d.Embedding
+	}
+	return vectors, nil
+}
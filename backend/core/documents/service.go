@@ -0,0 +1,273 @@
+// Package documents 负责 RAG 知识库的文档导入：切分、向量化并写入向量库，同时在本地保存文档与分块以便管理。
+package documents
+
+import (
+	"context"
+	"context-fabric/backend/core/domain"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidDocument 表示导入请求本身不合法（内容为空、参数越界等），而非下游服务失败。
+var ErrInvalidDocument = errors.New("invalid document")
+
+// Repository 定义了文档元数据与分块的持久化接口。
+type Repository interface {
+	Save(ctx context.Context, d *domain.Document) error
+	Get(ctx context.Context, id string) (*domain.Document, error)
+	List(ctx context.Context) ([]domain.DocumentSummary, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// VectorStore 是写入文档分块所需的向量库操作。
+type VectorStore interface {
+	EnsureCollection(ctx context.Context, collection string, dim int) error
+	UpsertPoints(ctx context.Context, collection string, points []domain.VectorPoint) error
+	DeletePoints(ctx context.Context, collection string, ids []string) error
+	DeleteByPayload(ctx context.Context, collection, key, value string) error
+}
+
+// Embedder 批量获取文本向量。
+type Embedder interface {
+	Embed(ctx context.Context, texts []string, modelID string) ([][]float32, error)
+}
+
+// IngestRequest 是一次文档导入请求，留空的集合与向量模型使用服务默认值。
+type IngestRequest struct {
+	ID             string `json:"id"` // 指定时覆盖同 ID 的已有文档
+	Title          string `json:"title"`
+	Source         string `json:"source"`
+	Format         string `json:"format"` // markdown 或 text，留空时按 Source 的扩展名判断
+	Content        string `json:"content"`
+	Collection     string `json:"collection"`
+	EmbeddingModel string `json:"embedding_model"`
+	ChunkOptions
+}
+
+var docIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// collectionPattern 限制集合名的字符，集合名会拼入向量库的 URL 路径。
+var collectionPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ValidateCollection 校验集合名。
+func ValidateCollection(name string) error {
+	if !collectionPattern.MatchString(name) {
+		return fmt.Errorf("collection %q must match %s", name, collectionPattern)
+	}
+	return nil
+}
+
+// Service 负责文档的导入、查询与删除。
+type Service struct {
+	repo       Repository
+	vectors    VectorStore
+	embedder   Embedder
+	index      *Index
+	collection string
+	model      string
+}
+
+// NewService 创建文档服务，collection 与 model 为默认的目标集合与向量模型，应与 RAGPass 的检索配置一致。
+func NewService(r Repository, v VectorStore, e Embedder, collection, model string) *Service {
+	return &Service{repo: r, vectors: v, embedder: e, collection: collection, model: model}
+}
+
+// WithIndex 设置关键词索引，导入与删除文档时同步更新。
+func (s *Service) WithIndex(idx *Index) *Service {
+	s.index = idx
+	return s
+}
+
+// LoadIndex 以本地保存的全部文档重建关键词索引，通常在启动时调用一次。
+func (s *Service) LoadIndex(ctx context.Context) error {
+	if s.index == nil {
+		return nil
+	}
+	list, err := s.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, summary := range list {
+		doc, err := s.repo.Get(ctx, summary.ID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.index.Add(doc)
+	}
+	return errors.Join(errs...)
+}
+
+// Ingest 切分文档、批量向量化并写入向量库，成功后保存文档记录。
+// 点 ID 由文档 ID 与分块序号决定，覆盖已有文档时先写入新分块（同序号直接覆盖），再删除多出的旧分块，
+// 因此向量化或写入失败时旧文档的向量保持完整。
+func (s *Service) Ingest(ctx context.Context, req IngestRequest) (*domain.Document, error) {
+	doc, err := s.prepare(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+	}
+
+	texts := make([]string, len(doc.Chunks))
+	for i, c := range doc.Chunks {
+		texts[i] = c.Text()
+	}
+	vectors, err := s.embedder.Embed(ctx, texts, doc.EmbeddingModel)
+	if err != nil {
+		return nil, fmt.Errorf("embed document %s: %w", doc.ID, err)
+	}
+
+	if err := s.vectors.EnsureCollection(ctx, doc.Collection, len(vectors[0])); err != nil {
+		return nil, fmt.Errorf("ensure collection %s: %w", doc.Collection, err)
+	}
+
+	points := make([]domain.VectorPoint, len(doc.Chunks))
+	for i, c := range doc.Chunks {
+		points[i] = domain.VectorPoint{
+			ID:     chunkPointID(doc.ID, c.Index),
+			Vector: vectors[i],
+			Payload: map[string]interface{}{
+				"content":     texts[i],
+				"doc_id":      doc.ID,
+				"title":       doc.Title,
+				"source":      doc.Source,
+				"chunk_index": c.Index,
+				"heading":     c.Heading,
+				"created_at":  doc.CreatedAt.Unix(),
+			},
+		}
+	}
+	if err := s.vectors.UpsertPoints(ctx, doc.Collection, points); err != nil {
+		return nil, fmt.Errorf("upsert chunks of %s: %w", doc.ID, err)
+	}
+	if old, err := s.repo.Get(ctx, doc.ID); err == nil {
+		if err := s.removeStale(ctx, old, doc); err != nil {
+			return nil, fmt.Errorf("delete stale chunks of %s: %w", doc.ID, err)
+		}
+	}
+	if err := s.repo.Save(ctx, doc); err != nil {
+		return nil, fmt.Errorf("save document %s: %w", doc.ID, err)
+	}
+	if s.index != nil {
+		s.index.Add(doc)
+	}
+	log.Printf("[Documents] Ingested %s (%q): %d chunks into %s", doc.ID, doc.Title, len(doc.Chunks), doc.Collection)
+	return doc, nil
+}
+
+// removeStale 删除旧版本中新版本未覆盖的分块：集合改变时删除旧集合中该文档的全部点，否则删除超出新分块数的点。
+func (s *Service) removeStale(ctx context.Context, old, doc *domain.Document) error {
+	if old.Collection != doc.Collection {
+		return s.vectors.DeleteByPayload(ctx, old.Collection, "doc_id", old.ID)
+	}
+	var stale []string
+	for _, c := range old.Chunks {
+		if c.Index >= len(doc.Chunks) {
+			stale = append(stale, chunkPointID(old.ID, c.Index))
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	return s.vectors.DeletePoints(ctx, doc.Collection, stale)
+}
+
+// prepare 校验请求、补全默认值并完成切分。
+func (s *Service) prepare(req IngestRequest) (*domain.Document, error) {
+	if req.ID != "" && !docIDPattern.MatchString(req.ID) {
+		return nil, fmt.Errorf("id %q must match %s", req.ID, docIDPattern)
+	}
+	if !utf8.ValidString(req.Content) {
+		return nil, errors.New("content must be UTF-8 text")
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		return nil, errors.New("content is empty")
+	}
+	opts := req.ChunkOptions
+	if opts.Size == 0 {
+		opts.Size = DefaultChunkOptions.Size
+		if opts.Overlap == 0 {
+			opts.Overlap = DefaultChunkOptions.Overlap
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	format := req.Format
+	if format == "" {
+		format = DetectFormat(req.Source)
+	}
+	if format != FormatMarkdown && format != FormatText {
+		return nil, fmt.Errorf("format must be %q or %q, got %q", FormatMarkdown, FormatText, format)
+	}
+
+	doc := &domain.Document{
+		ID:             req.ID,
+		Title:          req.Title,
+		Source:         req.Source,
+		Format:         format,
+		Collection:     req.Collection,
+		EmbeddingModel: req.EmbeddingModel,
+		ChunkSize:      opts.Size,
+		ChunkOverlap:   opts.Overlap,
+		Chunks:         Split(req.Content, format, opts),
+		CreatedAt:      time.Now(),
+	}
+	if doc.ID == "" {
+		doc.ID = "doc-" + uuid.New().String()
+	}
+	if doc.Title == "" {
+		doc.Title = req.Source
+	}
+	if doc.Collection == "" {
+		doc.Collection = s.collection
+	}
+	if doc.EmbeddingModel == "" {
+		doc.EmbeddingModel = s.model
+	}
+	if err := ValidateCollection(doc.Collection); err != nil {
+		return nil, err
+	}
+	if len(doc.Chunks) == 0 {
+		return nil, errors.New("content has no text to index")
+	}
+	return doc, nil
+}
+
+// chunkPointID 由文档 ID 与分块序号派生稳定的点 ID（Qdrant 要求 UUID 或整数）。
+func chunkPointID(docID string, index int) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(fmt.Sprintf("%s#%d", docID, index))).String()
+}
+
+func (s *Service) List(ctx context.Context) ([]domain.DocumentSummary, error) {
+	return s.repo.List(ctx)
+}
+
+func (s *Service) Get(ctx context.Context, id string) (*domain.Document, error) {
+	if !docIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("%w: id %q must match %s", ErrInvalidDocument, id, docIDPattern)
+	}
+	return s.repo.Get(ctx, id)
+}
+
+// Delete 删除文档在向量库中的全部分块及本地记录。
+func (s *Service) Delete(ctx context.Context, id string) error {
+	doc, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.vectors.DeleteByPayload(ctx, doc.Collection, "doc_id", doc.ID); err != nil {
+		return fmt.Errorf("delete chunks of %s: %w", doc.ID, err)
+	}
+	if s.index != nil {
+		s.index.Remove(doc.ID)
+	}
+	return s.repo.Delete(ctx, id)
+}
@@ -2,6 +2,7 @@ package domain
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 )
 
@@ -12,6 +13,68 @@ const (
 	RoleAssistant = "assistant"
 )
 
+// 消息在上下文中所属的层，记录在 Meta["layer"] 中，供 Token 预算按层分配配额。
+// 未标记的消息视为对话历史。
+const (
+	MetaLayer = "layer"
+
+	LayerSystem  = "system"  // 系统提示词
+	LayerMemory  = "memory"  // Constitution 注入的长期记忆与近期事实
+	LayerRAG     = "rag"     // RAG 检索片段
+	LayerSummary = "summary" // 历史会话摘要
+	LayerHistory = "history" // 对话历史
+)
+
+// Layers 按固定顺序列出所有上下文层。
+var Layers = []string{LayerSystem, LayerMemory, LayerRAG, LayerSummary, LayerHistory}
+
+// LayerOf 返回消息所属的上下文层。
+func LayerOf(m Message) string {
+	if layer, _ := m.Meta[MetaLayer].(string); layer != "" {
+		return layer
+	}
+	if isSummary, _ := m.Meta["is_summary"].(bool); isSummary {
+		return LayerSummary
+	}
+	return LayerHistory
+}
+
+// 消息的截断优先级，记录在 Meta 中。
+// 固定 (pinned) 的消息永不被截断；其余消息按优先级从低到高淘汰，同优先级先淘汰较旧的消息。
+const (
+	MetaPinned   = "pinned"   // bool
+	MetaPriority = "priority" // 数值，越大越晚被淘汰；未设置时按所属层取默认值
+)
+
+// MetaCitations 记录 RAG 注入片段的来源（[]Citation），由 RAG 消息与本轮请求的最后一条消息携带。
+const MetaCitations = "citations"
+
+// layerPriority 是各层消息的默认优先级。
+var layerPriority = map[string]int{
+	LayerSystem:  100,
+	LayerSummary: 80,
+	LayerMemory:  60,
+	LayerRAG:     50,
+	LayerHistory: 0,
+}
+
+// IsPinned 判断消息是否被固定。
+func IsPinned(m Message) bool {
+	pinned, _ := m.Meta[MetaPinned].(bool)
+	return pinned
+}
+
+// PriorityOf 返回消息的截断优先级。Meta 经 JSON 往返后数值为 float64，这里统一处理。
+func PriorityOf(m Message) int {
+	switch v := m.Meta[MetaPriority].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return layerPriority[LayerOf(m)]
+}
+
 // Message 代表会话中的单条消息
 type Message struct {
 	Role      string                 `json:"role"`
@@ -38,6 +101,27 @@ type Session struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Messages  []Message `json:"messages"`
+
+	// Summary 是滚动摘要，覆盖历史消息中下标小于 Summary.Covered 的部分。
+	Summary *RollingSummary `json:"summary,omitempty"`
+}
+
+// RollingSummary 是随会话持久化的增量摘要。
+// 新消息滑出保留窗口时，只将这部分消息与上一版摘要一起交给 LLM 更新。
+// 分层模式下新消息先按分段生成 Chunks，分段过多时最早的分段再汇总进 Content。
+type RollingSummary struct {
+	Content   string         `json:"content"` // 会话级摘要
+	Chunks    []SummaryChunk `json:"chunks,omitempty"`
+	Covered   int            `json:"covered"` // 已被摘要覆盖的历史消息数（从会话开头计）
+	Model     string         `json:"model"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// SummaryChunk 是分层摘要中覆盖历史消息 [From, To) 的一段摘要。
+type SummaryChunk struct {
+	Content string `json:"content"`
+	From    int    `json:"from"`
+	To      int    `json:"to"`
 }
 
 // SessionSummary 会话的摘要信息，用于列表展示
@@ -73,7 +157,8 @@ type StagingFact struct {
 	Content       string    `json:"content"`
 	SourceSession string    `json:"source_session"`
 	CreatedAt     time.Time `json:"created_at"`
-	Status        string    `json:"status"` // pending, processing, completed
+	Status        string    `json:"status"`          // pending, processing, completed
+	Score         float32   `json:"score,omitempty"` // 检索时与查询的相似度，仅检索结果携带
 }
 
 // SharedMemory 代表共享区中的可演进知识单元
@@ -86,20 +171,181 @@ type SharedMemory struct {
 	Version      int       `json:"version"`
 	Status       string    `json:"status"` // active, deprecated, disputed
 	LastVerified time.Time `json:"last_verified"`
-	EvidenceRefs []string  `json:"evidence_refs"` // 来源 StagingFact ID 列表
+	EvidenceRefs []string  `json:"evidence_refs"`   // 来源 StagingFact ID 列表
+	Score        float32   `json:"score,omitempty"` // 检索时与查询的相似度，仅检索结果携带
 }
 
 // VectorRepository 定义向量存储层的抽象接口
 type VectorRepository interface {
 	// StagingFact 操作
 	SaveStagingFact(ctx context.Context, fact *StagingFact) error
-	SearchStagingFacts(ctx context.Context, vector []float32, limit int) ([]StagingFact, error)
+	SearchStagingFacts(ctx context.Context, vector []float32, limit int, withVector bool) ([]StagingFact, error)
 	ListPendingFacts(ctx context.Context, limit int) ([]StagingFact, error)
 	DeleteStagingFact(ctx context.Context, id string) error
 
 	// SharedMemory 操作
 	SaveSharedMemory(ctx context.Context, mem *SharedMemory) error
-	SearchSharedMemories(ctx context.Context, vector []float32, limit int) ([]SharedMemory, error)
+	SearchSharedMemories(ctx context.Context, vector []float32, limit int, withVector bool) ([]SharedMemory, error)
 	UpdateSharedMemory(ctx context.Context, mem *SharedMemory) error
 	DeleteSharedMemory(ctx context.Context, id string) error
 }
+
+// DefaultPromptApp 是未单独配置模板的 AppID 回退使用的提示词模板键。
+const DefaultPromptApp = "default"
+
+// PromptTemplate 是某个 AppID 的系统提示词模板。版本只追加不修改，最新版本即当前生效版本。
+type PromptTemplate struct {
+	AppID     string          `json:"app_id"`
+	Versions  []PromptVersion `json:"versions"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Current 返回当前生效的版本，没有任何版本时返回 nil。
+func (t *PromptTemplate) Current() *PromptVersion {
+	if len(t.Versions) == 0 {
+		return nil
+	}
+	return &t.Versions[len(t.Versions)-1]
+}
+
+// PromptVersion 是提示词模板的一个版本，记录作者与变更说明以便审计。
+type PromptVersion struct {
+	Version   int       `json:"version"`
+	Content   string    `json:"content"` // Go text/template 模板，可用变量见 PromptVars
+	Author    string    `json:"author"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PromptTemplateSummary 提示词模板摘要，用于列表展示
+type PromptTemplateSummary struct {
+	AppID     string    `json:"app_id"`
+	Version   int       `json:"version"`
+	Author    string    `json:"author"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PromptVars 是渲染系统提示词模板时可用的变量。
+type PromptVars struct {
+	Now         time.Time // 当前时间（已转换到 Timezone）
+	Time        string    // 15:04:05
+	Date        string    // 2006-01-02
+	Timezone    string    // IANA 时区名，如 Asia/Shanghai
+	AppID       string
+	SessionID   string
+	SessionName string
+	ModelID     string
+	User        map[string]string // 请求携带的用户画像字段，缺失的字段渲染为空串
+	MemoryCount int               // 本次注入的长期记忆条数
+	FactCount   int               // 本次注入的近期事实条数
+}
+
+// Document 是导入 RAG 知识库的一篇文档及其切分结果，分块正文同时保存在本地以便查看与重建索引。
+type Document struct {
+	ID             string          `json:"id"`
+	Title          string          `json:"title"`
+	Source         string          `json:"source"` // 来源，通常为上传的文件名
+	Format         string          `json:"format"` // markdown 或 text
+	Collection     string          `json:"collection"`
+	EmbeddingModel string          `json:"embedding_model"`
+	ChunkSize      int             `json:"chunk_size"`
+	ChunkOverlap   int             `json:"chunk_overlap"`
+	Chunks         []DocumentChunk `json:"chunks"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// Summary 返回不含分块正文的文档摘要。
+func (d *Document) Summary() DocumentSummary {
+	return DocumentSummary{
+		ID:         d.ID,
+		Title:      d.Title,
+		Source:     d.Source,
+		Format:     d.Format,
+		Collection: d.Collection,
+		ChunkCount: len(d.Chunks),
+		CreatedAt:  d.CreatedAt,
+	}
+}
+
+// DocumentChunk 是文档的一个分块，Heading 为 Markdown 标题路径（如 "安装 > 依赖"）。
+type DocumentChunk struct {
+	Index   int    `json:"index"`
+	Heading string `json:"heading,omitempty"`
+	Content string `json:"content"`
+	Tokens  int    `json:"tokens"`
+}
+
+// Text 返回用于向量化与检索注入的分块文本，带有标题路径以保留所在章节的语境。
+func (c DocumentChunk) Text() string {
+	if c.Heading == "" {
+		return c.Content
+	}
+	return c.Heading + "\n\n" + c.Content
+}
+
+// DocumentSummary 文档摘要，用于列表展示
+type DocumentSummary struct {
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	Source     string    `json:"source"`
+	Format     string    `json:"format"`
+	Collection string    `json:"collection"`
+	ChunkCount int       `json:"chunk_count"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// VectorPoint 是写入向量库的一个点。
+type VectorPoint struct {
+	ID      string                 `json:"id"`
+	Vector  []float32              `json:"vector"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// RetrievedChunk 是一次知识库检索命中的分块，同时记录各检索器的得分与名次（0 表示该检索器未命中）。
+type RetrievedChunk struct {
+	ID           string    `json:"id"` // 向量库点 ID，用于合并不同检索器的结果
+	DocID        string    `json:"doc_id,omitempty"`
+	Collection   string    `json:"collection,omitempty"`
+	Title        string    `json:"title,omitempty"`
+	Source       string    `json:"source,omitempty"`
+	ChunkIndex   int       `json:"chunk_index"`
+	Content      string    `json:"content"`
+	Score        float64   `json:"score"` // 最终排序得分
+	VectorScore  float64   `json:"vector_score,omitempty"`
+	VectorRank   int       `json:"vector_rank,omitempty"`
+	LexicalScore float64   `json:"lexical_score,omitempty"`
+	LexicalRank  int       `json:"lexical_rank,omitempty"`
+	RerankScore  float64   `json:"rerank_score,omitempty"` // 交叉编码器重排得分
+	Vector       []float32 `json:"-"`                      // 向量库返回的向量，供 MMR 计算相似度
+}
+
+// Citation 是注入上下文的一条 RAG 片段的来源，Index 与注入文本中的编号标记 [n] 对应。
+type Citation struct {
+	Index      int     `json:"index"`
+	Collection string  `json:"collection,omitempty"`
+	DocID      string  `json:"doc_id,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	Source     string  `json:"source,omitempty"`
+	ChunkIndex int     `json:"chunk_index"`
+	Score      float64 `json:"score"`
+	Excerpt    string  `json:"excerpt"` // 片段开头，便于前端预览与事后核对
+}
+
+// RAGCollection 是一次 RAG 检索的目标集合及其参数，零值字段沿用 RAGPass 的配置。
+// JSON 中既可写成对象，也可直接写集合名字符串。
+type RAGCollection struct {
+	Name           string  `json:"name"`
+	TopK           int     `json:"top_k,omitempty"`           // 该集合最多贡献的结果数
+	Weight         float64 `json:"weight,omitempty"`          // 跨集合合并时的 RRF 权重
+	EmbeddingModel string  `json:"embedding_model,omitempty"` // 集合使用的向量模型
+}
+
+func (c *RAGCollection) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*c = RAGCollection{Name: name}
+		return nil
+	}
+	type plain RAGCollection
+	return json.Unmarshal(b, (*plain)(c))
+}
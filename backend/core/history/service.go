@@ -4,6 +4,7 @@ import (
 	"context"
 	"context-fabric/backend/core/domain"
 	"fmt"
+	"sync"
 	"time"
 )
 
@@ -26,6 +27,7 @@ type TestCaseRepository interface {
 type Service struct {
 	repo   Repository
 	tcRepo TestCaseRepository
+	locks  sync.Map // 会话 ID -> *sync.Mutex，串行化同一会话的读改写
 }
 
 func NewService(r Repository, tr TestCaseRepository) *Service {
@@ -87,6 +89,7 @@ func (s *Service) Get(ctx context.Context, id string) (*domain.Session, error) {
 }
 
 func (s *Service) GetOrCreateSession(ctx context.Context, id, appID string) (*domain.Session, error) {
+	defer s.lock(id)()
 	sess, err := s.Get(ctx, id)
 	if err == nil {
 		return sess, nil
@@ -104,26 +107,45 @@ func (s *Service) GetOrCreateSession(ctx context.Context, id, appID string) (*do
 	return newS, nil
 }
 
-func (s *Service) Append(ctx context.Context, id string, msg domain.Message) error {
+// lock 获取会话锁并返回解锁函数。
+func (s *Service) lock(id string) func() {
+	v, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// update 在会话锁内读取会话、调用 fn 修改并保存，fn 返回 false 时不保存。
+// 所有读改写操作都经过这里，管线中途（如摘要的慢速 LLM 调用之后）的写入不会覆盖并发追加的消息。
+func (s *Service) update(ctx context.Context, id string, fn func(sess *domain.Session) bool) error {
+	defer s.lock(id)()
+
 	sess, err := s.Get(ctx, id)
 	if err != nil {
 		return err
 	}
-	sess.Messages = append(sess.Messages, msg)
+	if !fn(sess) {
+		return nil
+	}
 	return s.Save(ctx, sess)
 }
 
+func (s *Service) Append(ctx context.Context, id string, msg domain.Message) error {
+	return s.update(ctx, id, func(sess *domain.Session) bool {
+		sess.Messages = append(sess.Messages, msg)
+		return true
+	})
+}
+
 func (s *Service) List(ctx context.Context) ([]domain.SessionSummary, error) {
 	return s.repo.List(ctx)
 }
 
 func (s *Service) Rename(ctx context.Context, id, newName string) error {
-	sess, err := s.Get(ctx, id)
-	if err != nil {
-		return err
-	}
-	sess.Name = newName
-	return s.Save(ctx, sess)
+	return s.update(ctx, id, func(sess *domain.Session) bool {
+		sess.Name = newName
+		return true
+	})
 }
 
 func (s *Service) Delete(ctx context.Context, id string) error {
@@ -134,14 +156,20 @@ func (s *Service) DeleteBatch(ctx context.Context, ids []string) error {
 	return s.repo.DeleteBatch(ctx, ids)
 }
 
+// SaveSummary 更新会话的滚动摘要。
+func (s *Service) SaveSummary(ctx context.Context, id string, summary *domain.RollingSummary) error {
+	return s.update(ctx, id, func(sess *domain.Session) bool {
+		sess.Summary = summary
+		return true
+	})
+}
+
 func (s *Service) UpdateLastMessageMeta(ctx context.Context, id string, meta map[string]interface{}) error {
-	sess, err := s.Get(ctx, id)
-	if err != nil {
-		return err
-	}
-	if len(sess.Messages) > 0 {
+	return s.update(ctx, id, func(sess *domain.Session) bool {
+		if len(sess.Messages) == 0 {
+			return false
+		}
 		sess.Messages[len(sess.Messages)-1].Meta = meta
-		return s.Save(ctx, sess)
-	}
-	return nil
+		return true
+	})
 }
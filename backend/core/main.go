@@ -1,10 +1,15 @@
 package main
 
 import (
+	stdctx "context"
 	"context-fabric/backend/core/api"
 	"context-fabric/backend/core/context"
+	"context-fabric/backend/core/documents"
 	"context-fabric/backend/core/history"
 	"context-fabric/backend/core/persistence"
+	"context-fabric/backend/core/prompts"
+	"context-fabric/backend/core/tokens"
+	"context-fabric/backend/core/util"
 	"log"
 	"net/http"
 	"os"
@@ -62,6 +67,36 @@ func getQdrantConfig() (string, string, string) {
 	return url, staging, shared
 }
 
+// loadPipelineConfig 读取声明式管线配置；未设置 AGENTIC_PIPELINE_CONFIG 时返回 nil 以使用内置默认管线
+func loadPipelineConfig() (*context.PipelineConfig, error) {
+	path := os.Getenv("AGENTIC_PIPELINE_CONFIG")
+	if path == "" {
+		return nil, nil
+	}
+	log.Printf("[CORE] Pipeline config: %s", path)
+	return context.LoadPipelineConfig(path)
+}
+
+// loadModelCatalog 加载 AGENTIC_MODEL_CATALOG 指向的自定义模型目录，未设置时仅使用内置目录
+func loadModelCatalog() error {
+	path := os.Getenv("AGENTIC_MODEL_CATALOG")
+	if path == "" {
+		return nil
+	}
+	log.Printf("[CORE] Model catalog: %s", path)
+	return tokens.LoadCatalog(path)
+}
+
+// loadLocaleTemplates 加载 AGENTIC_LOCALE_TEMPLATES 指向的注入模板，覆盖同名的内置模板
+func loadLocaleTemplates() error {
+	path := os.Getenv("AGENTIC_LOCALE_TEMPLATES")
+	if path == "" {
+		return nil
+	}
+	log.Printf("[CORE] Locale templates: %s", path)
+	return prompts.LoadLocales(path)
+}
+
 func main() {
 	// 1. 初始化持久化层
 	sessionDir := getSessionDir()
@@ -73,6 +108,12 @@ func main() {
 
 	repo, _ := persistence.NewFileHistoryRepository(sessionDir)
 	tcRepo, _ := persistence.NewFileTestCaseRepository(testcaseDir)
+	promptDir := filepath.Join(filepath.Dir(sessionDir), "prompts")
+	log.Printf("[CORE] Prompt storage: %s", promptDir)
+	pRepo, _ := persistence.NewFilePromptRepository(promptDir)
+	docDir := filepath.Join(filepath.Dir(sessionDir), "documents")
+	log.Printf("[CORE] Document storage: %s", docDir)
+	dRepo, _ := persistence.NewFileDocumentRepository(docDir)
 
 	// 1.1 初始化向量存储层 (DEMA)
 	qURL, qStaging, qShared := getQdrantConfig()
@@ -82,7 +123,29 @@ func main() {
 	// 2. 初始化核心服务
 	mSvc := context.NewMemoryService(vRepo, llmServiceURL)
 	hSvc := history.NewService(repo, tcRepo)
-	cEng := context.NewEngine(hSvc, llmServiceURL, mSvc)
+	pSvc := prompts.NewService(pRepo)
+	// 导入的目标集合与向量模型与 RAGPass 的检索默认值保持一致
+	dIdx := documents.NewIndex()
+	dSvc := documents.NewService(dRepo, vRepo, documents.NewGatewayEmbedder(llmServiceURL),
+		util.GetEnv("QDRANT_COLLECTION", "documents"),
+		util.GetEnv("RAG_EMBEDDING_MODEL", "text-embedding-3-small")).WithIndex(dIdx)
+	if err := dSvc.LoadIndex(stdctx.Background()); err != nil {
+		log.Printf("[CORE] Warning: some documents could not be indexed: %v", err)
+	}
+	if err := loadModelCatalog(); err != nil {
+		log.Fatalf("[CORE] Failed to load model catalog: %v", err)
+	}
+	if err := loadLocaleTemplates(); err != nil {
+		log.Fatalf("[CORE] Failed to load locale templates: %v", err)
+	}
+	pCfg, err := loadPipelineConfig()
+	if err != nil {
+		log.Fatalf("[CORE] Failed to load pipeline config: %v", err)
+	}
+	cEng, err := context.NewEngine(hSvc, llmServiceURL, mSvc, pSvc, dIdx, pCfg)
+	if err != nil {
+		log.Fatalf("[CORE] Failed to build pipeline: %v", err)
+	}
 	cSvc := context.NewService(hSvc, cEng, mSvc)
 
 	// 3. 配置路由
@@ -93,6 +156,8 @@ func main() {
 	mux.HandleFunc("/api/v1/sessions", ctxHandler.CreateSession)
 	mux.HandleFunc("/api/v1/messages", ctxHandler.AppendMessage)
 	mux.HandleFunc("/api/v1/context", ctxHandler.GetContext)
+	mux.HandleFunc("/api/v1/context/explain", ctxHandler.ExplainContext)
+	mux.HandleFunc("/api/v1/tokens/count", ctxHandler.CountTokens)
 
 	// 管理后台接口
 	admin := api.NewAdminHandler(hSvc, vRepo, mSvc)
@@ -105,6 +170,17 @@ func main() {
 	mux.HandleFunc("/api/admin/memory/status", admin.GetMemoryStatus)
 	mux.HandleFunc("/api/admin/logs", admin.ServeLogs)
 	mux.HandleFunc("/api/admin/docs", admin.ServeDocs)
+	mux.HandleFunc("/api/admin/pipeline/passes", admin.ServePassRegistry)
+
+	// 系统提示词模板管理
+	promptHandler := api.NewPromptHandler(pSvc)
+	mux.HandleFunc("/api/admin/prompts", promptHandler.ServePrompts)
+	mux.HandleFunc("/api/admin/prompts/", promptHandler.ServePrompts)
+
+	// RAG 知识库文档导入
+	docHandler := api.NewDocumentHandler(dSvc)
+	mux.HandleFunc("/api/admin/documents", docHandler.ServeDocuments)
+	mux.HandleFunc("/api/admin/documents/", docHandler.ServeDocuments)
 
 	// 4. 启动服务
 	log.Printf("[CORE] Listening on 9091...")
@@ -0,0 +1,80 @@
+package persistence
+
+import (
+	"context"
+	"context-fabric/backend/core/domain"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileDocumentRepository 将每篇导入的文档（含分块正文）存为一个 JSON 文件。
+type FileDocumentRepository struct {
+	basePath string
+}
+
+func NewFileDocumentRepository(base string) (*FileDocumentRepository, error) {
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return nil, err
+	}
+	return &FileDocumentRepository{basePath: base}, nil
+}
+
+func (r *FileDocumentRepository) documentPath(id string) string {
+	return filepath.Join(r.basePath, id+".json")
+}
+
+func (r *FileDocumentRepository) Save(ctx context.Context, d *domain.Document) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := r.documentPath(d.ID) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write document file: %w", err)
+	}
+	return os.Rename(tmpPath, r.documentPath(d.ID))
+}
+
+func (r *FileDocumentRepository) Get(ctx context.Context, id string) (*domain.Document, error) {
+	data, err := os.ReadFile(r.documentPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var d domain.Document
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse document %s: %w", id, err)
+	}
+	return &d, nil
+}
+
+func (r *FileDocumentRepository) List(ctx context.Context) ([]domain.DocumentSummary, error) {
+	files, err := os.ReadDir(r.basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []domain.DocumentSummary{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		d, err := r.Get(ctx, strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		list = append(list, d.Summary())
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+func (r *FileDocumentRepository) Delete(ctx context.Context, id string) error {
+	return os.Remove(r.documentPath(id))
+}
@@ -0,0 +1,105 @@
+package persistence
+
+import (
+	"context"
+	"context-fabric/backend/core/domain"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// promptAppIDPattern 限制拼入文件路径的 AppID，会话中的 AppID 未经校验，必须排除路径分隔符。
+var promptAppIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// FilePromptRepository 将每个 AppID 的提示词模板（含全部版本）存为一个 JSON 文件。
+type FilePromptRepository struct {
+	basePath string
+}
+
+func NewFilePromptRepository(base string) (*FilePromptRepository, error) {
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return nil, err
+	}
+	return &FilePromptRepository{basePath: base}, nil
+}
+
+// promptPath 返回 AppID 对应的文件路径；非法的 AppID 不可能存在对应模板，返回的错误满足 fs.ErrNotExist。
+func (r *FilePromptRepository) promptPath(appID string) (string, error) {
+	if !promptAppIDPattern.MatchString(appID) {
+		return "", fmt.Errorf("invalid app_id %q: %w", appID, fs.ErrNotExist)
+	}
+	return filepath.Join(r.basePath, appID+".json"), nil
+}
+
+func (r *FilePromptRepository) Save(ctx context.Context, t *domain.PromptTemplate) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	path, err := r.promptPath(t.AppID)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write prompt file: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (r *FilePromptRepository) Get(ctx context.Context, appID string) (*domain.PromptTemplate, error) {
+	path, err := r.promptPath(appID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t domain.PromptTemplate
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse prompt %s: %w", appID, err)
+	}
+	return &t, nil
+}
+
+func (r *FilePromptRepository) List(ctx context.Context) ([]domain.PromptTemplateSummary, error) {
+	files, err := os.ReadDir(r.basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []domain.PromptTemplateSummary{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		t, err := r.Get(ctx, strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		s := domain.PromptTemplateSummary{AppID: t.AppID, UpdatedAt: t.UpdatedAt}
+		if cur := t.Current(); cur != nil {
+			s.Version, s.Author = cur.Version, cur.Author
+		}
+		list = append(list, s)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].AppID < list[j].AppID
+	})
+	return list, nil
+}
+
+func (r *FilePromptRepository) Delete(ctx context.Context, appID string) error {
+	path, err := r.promptPath(appID)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,53 @@
+package persistence
+
+import (
+	"context"
+	"context-fabric/backend/core/domain"
+	"context-fabric/backend/core/prompts"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPromptRepositoryRejectsTraversal(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	repo, err := NewFilePromptRepository(filepath.Join(root, "prompts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 提示词目录之外的 JSON 文件，不能经由会话的 AppID 读到
+	outside := domain.PromptTemplate{AppID: "evil", Versions: []domain.PromptVersion{{Version: 1, Content: "LEAKED"}}}
+	raw, _ := json.Marshal(outside)
+	if err := os.WriteFile(filepath.Join(root, "evil.json"), raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"../evil", "..\\evil", "a/b", ""} {
+		if _, err := repo.Get(ctx, id); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Get(%q) error = %v, want fs.ErrNotExist", id, err)
+		}
+		if err := repo.Save(ctx, &domain.PromptTemplate{AppID: id}); err == nil {
+			t.Errorf("Save(%q) succeeded, want error", id)
+		}
+		if err := repo.Delete(ctx, id); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Delete(%q) error = %v, want fs.ErrNotExist", id, err)
+		}
+	}
+
+	svc := prompts.NewService(repo)
+	if _, err := svc.Publish(ctx, domain.DefaultPromptApp, "default prompt", "test", ""); err != nil {
+		t.Fatal(err)
+	}
+	out, version, err := svc.Render(ctx, "../evil", domain.PromptVars{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "default prompt" || version != 1 {
+		t.Errorf("Render(../evil) = %q (v%d), want the default prompt", out, version)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 )
 
 type QdrantRepository struct {
@@ -62,7 +63,8 @@ func (r *QdrantRepository) SaveStagingFact(ctx context.Context, fact *domain.Sta
 	return nil
 }
 
-func (r *QdrantRepository) SearchStagingFacts(ctx context.Context, vector []float32, limit int) ([]domain.StagingFact, error) {
+// SearchStagingFacts 检索近期事实，withVector 为 true 时一并返回向量（供 MMR 计算相似度）。
+func (r *QdrantRepository) SearchStagingFacts(ctx context.Context, vector []float32, limit int, withVector bool) ([]domain.StagingFact, error) {
 	log.Printf("[Qdrant] Searching staging facts (limit: %d)", limit)
 	endpoint := fmt.Sprintf("%s/collections/%s/points/search", r.baseURL, r.stagingColl)
 
@@ -70,6 +72,7 @@ func (r *QdrantRepository) SearchStagingFacts(ctx context.Context, vector []floa
 		"vector":       vector,
 		"limit":        limit,
 		"with_payload": true,
+		"with_vector":  withVector,
 	}
 
 	data, _ := json.Marshal(payload)
@@ -90,6 +93,8 @@ func (r *QdrantRepository) SearchStagingFacts(ctx context.Context, vector []floa
 	var result struct {
 		Result []struct {
 			ID      string                 `json:"id"`
+			Score   float32                `json:"score"`
+			Vector  []float32              `json:"vector"`
 			Payload map[string]interface{} `json:"payload"`
 		} `json:"result"`
 	}
@@ -101,6 +106,8 @@ func (r *QdrantRepository) SearchStagingFacts(ctx context.Context, vector []floa
 	for _, item := range result.Result {
 		f := domain.StagingFact{
 			ID:      item.ID,
+			Vector:  item.Vector,
+			Score:   item.Score,
 			Content: item.Payload["content"].(string),
 			Status:  item.Payload["status"].(string),
 		}
@@ -225,7 +232,8 @@ func (r *QdrantRepository) SaveSharedMemory(ctx context.Context, mem *domain.Sha
 	return nil
 }
 
-func (r *QdrantRepository) SearchSharedMemories(ctx context.Context, vector []float32, limit int) ([]domain.SharedMemory, error) {
+// SearchSharedMemories 检索长期记忆，withVector 为 true 时一并返回向量（供 MMR 计算相似度）。
+func (r *QdrantRepository) SearchSharedMemories(ctx context.Context, vector []float32, limit int, withVector bool) ([]domain.SharedMemory, error) {
 	log.Printf("[Qdrant] Searching shared memories (limit: %d)", limit)
 	endpoint := fmt.Sprintf("%s/collections/%s/points/search", r.baseURL, r.sharedColl)
 
@@ -233,6 +241,7 @@ func (r *QdrantRepository) SearchSharedMemories(ctx context.Context, vector []fl
 		"vector":       vector,
 		"limit":        limit,
 		"with_payload": true,
+		"with_vector":  withVector,
 	}
 
 	data, _ := json.Marshal(payload)
@@ -253,6 +262,8 @@ func (r *QdrantRepository) SearchSharedMemories(ctx context.Context, vector []fl
 	var result struct {
 		Result []struct {
 			ID      string                 `json:"id"`
+			Score   float32                `json:"score"`
+			Vector  []float32              `json:"vector"`
 			Payload map[string]interface{} `json:"payload"`
 		} `json:"result"`
 	}
@@ -264,6 +275,8 @@ func (r *QdrantRepository) SearchSharedMemories(ctx context.Context, vector []fl
 	for _, item := range result.Result {
 		m := domain.SharedMemory{
 			ID:      item.ID,
+			Vector:  item.Vector,
+			Score:   item.Score,
 			Content: item.Payload["content"].(string),
 			Topic:   item.Payload["topic"].(string),
 			Version: int(item.Payload["version"].(float64)),
@@ -303,7 +316,7 @@ func (r *QdrantRepository) DeleteSharedMemory(ctx context.Context, id string) er
 
 // DeletePoints Generic delete for admin
 func (r *QdrantRepository) DeletePoints(ctx context.Context, collection string, ids []string) error {
-	endpoint := fmt.Sprintf("%s/collections/%s/points/delete?wait=true", r.baseURL, collection)
+	endpoint := fmt.Sprintf("%s/collections/%s/points/delete?wait=true", r.baseURL, url.PathEscape(collection))
 
 	payload := map[string]interface{}{
 		"points": ids,
@@ -358,3 +371,102 @@ func (r *QdrantRepository) ScrollPoints(ctx context.Context, collection string,
 	}
 	return result, nil
 }
+
+// EnsureCollection 在集合不存在时按向量维度创建（余弦距离）。
+func (r *QdrantRepository) EnsureCollection(ctx context.Context, collection string, dim int) error {
+	endpoint := fmt.Sprintf("%s/collections/%s", r.baseURL, url.PathEscape(collection))
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == 200 {
+		return nil
+	}
+	if resp.StatusCode != 404 {
+		return fmt.Errorf("qdrant collection info error: %s", resp.Status)
+	}
+
+	log.Printf("[Qdrant] Creating collection %s (dim: %d)", collection, dim)
+	payload := map[string]interface{}{
+		"vectors": map[string]interface{}{
+			"size":     dim,
+			"distance": "Cosine",
+		},
+	}
+
+	data, _ := json.Marshal(payload)
+	req, _ = http.NewRequestWithContext(ctx, "PUT", endpoint, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err = r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("qdrant create collection error: %s - %s", resp.Status, string(body))
+	}
+	return nil
+}
+
+// UpsertPoints 批量写入（覆盖同 ID 的）点。
+func (r *QdrantRepository) UpsertPoints(ctx context.Context, collection string, points []domain.VectorPoint) error {
+	endpoint := fmt.Sprintf("%s/collections/%s/points?wait=true", r.baseURL, url.PathEscape(collection))
+
+	payload := map[string]interface{}{
+		"points": points,
+	}
+
+	data, _ := json.Marshal(payload)
+	req, _ := http.NewRequestWithContext(ctx, "PUT", endpoint, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("qdrant upsert error: %s - %s", resp.Status, string(body))
+	}
+	return nil
+}
+
+// DeleteByPayload 删除 payload 中 key 等于 value 的全部点，集合不存在时视为已删除。
+func (r *QdrantRepository) DeleteByPayload(ctx context.Context, collection, key, value string) error {
+	endpoint := fmt.Sprintf("%s/collections/%s/points/delete?wait=true", r.baseURL, url.PathEscape(collection))
+
+	payload := map[string]interface{}{
+		"filter": map[string]interface{}{
+			"must": []map[string]interface{}{
+				{"key": key, "match": map[string]interface{}{"value": value}},
+			},
+		},
+	}
+
+	data, _ := json.Marshal(payload)
+	req, _ := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("qdrant delete by filter error: %s - %s", resp.Status, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Condition 是基于 ContextData.Meta 与内置变量（如 msg_count）的 Pass 执行守卫条件。
+//
+// 支持的写法：
+//   - "rag_enabled"                     键值为真（true、非零数字、非空字符串）
+//   - "!rag_enabled"                    键值为假或不存在
+//   - "msg_count > 20"                  数值比较，支持 == != > >= < <=
+//   - "model_id matches deepseek-*"     glob 匹配（path.Match 语义）
+type Condition struct {
+	Expr   string
+	key    string
+	op     string
+	value  string
+	negate bool
+}
+
+var conditionOps = map[string]bool{
+	"==": true, "!=": true, ">": true, ">=": true, "<": true, "<=": true, "matches": true,
+}
+
+// ParseCondition 解析守卫条件表达式。
+func ParseCondition(expr string) (Condition, error) {
+	c := Condition{Expr: expr}
+	fields := strings.Fields(expr)
+	switch {
+	case len(fields) == 1:
+		c.key = fields[0]
+		if strings.HasPrefix(c.key, "!") {
+			c.negate = true
+			c.key = strings.TrimPrefix(c.key, "!")
+		}
+	case len(fields) >= 3:
+		c.key, c.op = fields[0], fields[1]
+		if !conditionOps[c.op] {
+			return c, fmt.Errorf("condition %q: unsupported operator %q", expr, c.op)
+		}
+		c.value = strings.Trim(strings.Join(fields[2:], " "), `"'`)
+		if c.op == "matches" {
+			if _, err := path.Match(c.value, ""); err != nil {
+				return c, fmt.Errorf("condition %q: invalid pattern: %w", expr, err)
+			}
+		}
+	default:
+		return c, fmt.Errorf("condition %q: expected \"key\", \"!key\" or \"key <op> value\"", expr)
+	}
+	if c.key == "" {
+		return c, fmt.Errorf("condition %q: missing key", expr)
+	}
+	return c, nil
+}
+
+// ParseConditions 批量解析守卫条件。
+func ParseConditions(exprs []string) ([]Condition, error) {
+	conds := make([]Condition, 0, len(exprs))
+	for _, expr := range exprs {
+		c, err := ParseCondition(expr)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, c)
+	}
+	return conds, nil
+}
+
+// Eval 针对内置变量与 Meta 求值（同名时内置变量优先），不满足时返回人类可读的原因。
+func (c Condition) Eval(meta, builtins map[string]interface{}) (bool, string) {
+	actual, exists := builtins[c.key]
+	if !exists {
+		actual, exists = meta[c.key]
+	}
+
+	if c.op == "" {
+		if truthy(actual) != c.negate {
+			return true, ""
+		}
+		if c.negate {
+			return false, fmt.Sprintf("%s is set (%v)", c.key, actual)
+		}
+		if !exists {
+			return false, fmt.Sprintf("%s is not set", c.key)
+		}
+		return false, fmt.Sprintf("%s is %v", c.key, actual)
+	}
+
+	if !exists {
+		return false, fmt.Sprintf("%s is not set", c.key)
+	}
+
+	var ok bool
+	switch c.op {
+	case "matches":
+		ok, _ = path.Match(c.value, fmt.Sprint(actual))
+	case "==", "!=":
+		ok = equal(actual, c.value) == (c.op == "==")
+	default:
+		a, aok := toFloat(actual)
+		b, err := strconv.ParseFloat(c.value, 64)
+		if !aok || err != nil {
+			return false, fmt.Sprintf("%s (%v) is not comparable with %s", c.key, actual, c.value)
+		}
+		switch c.op {
+		case ">":
+			ok = a > b
+		case ">=":
+			ok = a >= b
+		case "<":
+			ok = a < b
+		case "<=":
+			ok = a <= b
+		}
+	}
+	if ok {
+		return true, ""
+	}
+	return false, fmt.Sprintf("%s is %v, want %s %s", c.key, actual, c.op, c.value)
+}
+
+// EvalConditions 依次求值，返回首个不满足的条件原因。
+func EvalConditions(conds []Condition, meta, builtins map[string]interface{}) (bool, string) {
+	for _, c := range conds {
+		if ok, reason := c.Eval(meta, builtins); !ok {
+			return false, fmt.Sprintf("condition %q not met: %s", c.Expr, reason)
+		}
+	}
+	return true, ""
+}
+
+func truthy(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case string:
+		return x != ""
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+func equal(actual interface{}, expected string) bool {
+	if a, ok := toFloat(actual); ok {
+		if b, err := strconv.ParseFloat(expected, 64); err == nil {
+			return a == b
+		}
+	}
+	return fmt.Sprint(actual) == expected
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		expr    string
+		key     string
+		op      string
+		value   string
+		negate  bool
+		wantErr bool
+	}{
+		{expr: "rag_enabled", key: "rag_enabled"},
+		{expr: "!rag_enabled", key: "rag_enabled", negate: true},
+		{expr: "msg_count > 20", key: "msg_count", op: ">", value: "20"},
+		{expr: "model_id matches deepseek-*", key: "model_id", op: "matches", value: "deepseek-*"},
+		{expr: `app_id == "support bot"`, key: "app_id", op: "==", value: "support bot"},
+		{expr: "msg_count >", wantErr: true},
+		{expr: "msg_count ~ 3", wantErr: true},
+		{expr: "model_id matches [", wantErr: true},
+		{expr: "!", wantErr: true},
+		{expr: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		c, err := ParseCondition(tt.expr)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseCondition(%q) = %+v, want error", tt.expr, c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCondition(%q) error: %v", tt.expr, err)
+			continue
+		}
+		if c.key != tt.key || c.op != tt.op || c.value != tt.value || c.negate != tt.negate {
+			t.Errorf("ParseCondition(%q) = {key:%q op:%q value:%q negate:%v}, want {key:%q op:%q value:%q negate:%v}",
+				tt.expr, c.key, c.op, c.value, c.negate, tt.key, tt.op, tt.value, tt.negate)
+		}
+	}
+}
+
+func TestConditionEval(t *testing.T) {
+	meta := map[string]interface{}{
+		"rag_enabled": true,
+		"empty":       "",
+		"model_id":    "deepseek-chat",
+		"turns":       json.Number("3"),
+		"score":       0.5,
+		"msg_count":   999, // 内置变量优先于同名 Meta 键
+	}
+	builtins := map[string]interface{}{"msg_count": 12}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"rag_enabled", true},
+		{"!rag_enabled", false},
+		{"empty", false},
+		{"!empty", true},
+		{"missing", false},
+		{"!missing", true},
+		{"msg_count > 10", true},
+		{"msg_count >= 12", true},
+		{"msg_count < 12", false},
+		{"msg_count == 12", true},
+		{"msg_count != 12", false},
+		{"turns <= 3", true},
+		{"score == 0.5", true},
+		{"model_id matches deepseek-*", true},
+		{"model_id matches gpt-*", false},
+		{"model_id == deepseek-chat", true},
+		{"model_id > 3", false}, // 非数值不可比较
+		{"missing == 1", false},
+	}
+	for _, tt := range tests {
+		c, err := ParseCondition(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCondition(%q): %v", tt.expr, err)
+		}
+		got, reason := c.Eval(meta, builtins)
+		if got != tt.want {
+			t.Errorf("Eval(%q) = %v (%s), want %v", tt.expr, got, reason, tt.want)
+		}
+		if !got && reason == "" {
+			t.Errorf("Eval(%q) returned no reason", tt.expr)
+		}
+	}
+}
+
+func TestEvalConditionsReportsFirstFailure(t *testing.T) {
+	conds, err := ParseConditions([]string{"rag_enabled", "msg_count > 5", "model_id matches gpt-*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, reason := EvalConditions(conds, map[string]interface{}{"rag_enabled": true}, map[string]interface{}{"msg_count": 2})
+	if ok {
+		t.Fatal("EvalConditions = true, want false")
+	}
+	if want := `condition "msg_count > 5" not met: msg_count is 2, want > 5`; reason != want {
+		t.Errorf("reason = %q, want %q", reason, want)
+	}
+}
@@ -0,0 +1,232 @@
+package pipeline
+
+import (
+	"context-fabric/backend/core/domain"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// 消息差异操作类型。
+const (
+	DiffInsert = "insert"
+	DiffRemove = "remove"
+	DiffModify = "modify"
+)
+
+// SnapshotMessage 是 Trace 中记录的消息投影，只保留观测所需的角色与内容。
+type SnapshotMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// DiffOp 是一条消息差异操作。
+// 所有操作按顺序作用于上一个快照的副本：Index 为操作发生时在工作列表中的位置
+// （对 insert/modify 而言即该消息在新快照中的下标），OldIndex 为被删除或改写的消息在上一个快照中的下标。
+type DiffOp struct {
+	Op       string `json:"op"`
+	Index    int    `json:"index"`
+	OldIndex int    `json:"old_index,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// MessageDiff 描述某个 Pass 执行后相对上一个 Pass 的消息变化。
+type MessageDiff struct {
+	BaseCount int      `json:"base_count"` // 上一个快照的消息数，用于重建时校验
+	Count     int      `json:"count"`      // 应用后的消息数
+	Ops       []DiffOp `json:"ops,omitempty"`
+}
+
+// snapshotOf 将消息列表转换为快照投影。
+// 这确保了记录的是该时刻的静态内容，不会受到后续 Pass 对原始消息对象修改的影响。
+func snapshotOf(msgs []domain.Message) []SnapshotMessage {
+	snap := make([]SnapshotMessage, len(msgs))
+	for i, m := range msgs {
+		snap[i] = SnapshotMessage{Role: m.Role, Content: m.Content}
+	}
+	return snap
+}
+
+// DiffMessages 基于最长公共子序列计算 prev 到 next 的差异。
+// 先剥离相同的前缀与后缀，只对中间的变更区间建 LCS 表；大多数 Pass 只在局部插入或改写少量消息，
+// 长会话也只需要很小的表。同一段变更区间内角色相同的删除与插入会被合并为 modify。
+func DiffMessages(prev, next []SnapshotMessage) MessageDiff {
+	n, m := len(prev), len(next)
+	pre := 0
+	for pre < n && pre < m && prev[pre] == next[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < n-pre && suf < m-pre && prev[n-1-suf] == next[m-1-suf] {
+		suf++
+	}
+	a, b := prev[pre:n-suf], next[pre:m-suf]
+
+	// lcs[i][j] 为 a[i:] 与 b[j:] 的最长公共子序列长度
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	diff := MessageDiff{BaseCount: n, Count: m}
+	pos := pre // 当前在工作列表中的位置
+	var removed, inserted []int
+
+	// flush 输出一段变更区间内的操作
+	flush := func() {
+		for k := 0; k < len(removed) || k < len(inserted); k++ {
+			switch {
+			case k < len(removed) && k < len(inserted) && prev[removed[k]].Role == next[inserted[k]].Role:
+				msg := next[inserted[k]]
+				diff.Ops = append(diff.Ops, DiffOp{Op: DiffModify, Index: pos, OldIndex: removed[k], Role: msg.Role, Content: msg.Content})
+				pos++
+			default:
+				if k < len(removed) {
+					diff.Ops = append(diff.Ops, DiffOp{Op: DiffRemove, Index: pos, OldIndex: removed[k]})
+				}
+				if k < len(inserted) {
+					msg := next[inserted[k]]
+					diff.Ops = append(diff.Ops, DiffOp{Op: DiffInsert, Index: pos, Role: msg.Role, Content: msg.Content})
+					pos++
+				}
+			}
+		}
+		removed, inserted = removed[:0], inserted[:0]
+	}
+
+	// removed / inserted 记录的是在 prev / next 中的下标
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flush()
+			pos++
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			inserted = append(inserted, pre+j)
+			j++
+		default:
+			removed = append(removed, pre+i)
+			i++
+		}
+	}
+	flush()
+	return diff
+}
+
+// Apply 将差异应用到上一个快照，返回新的快照。
+func (d MessageDiff) Apply(prev []SnapshotMessage) ([]SnapshotMessage, error) {
+	if len(prev) != d.BaseCount {
+		return nil, fmt.Errorf("diff expects %d base messages, got %d", d.BaseCount, len(prev))
+	}
+	out := append([]SnapshotMessage(nil), prev...)
+	for i, op := range d.Ops {
+		switch op.Op {
+		case DiffInsert:
+			if op.Index < 0 || op.Index > len(out) {
+				return nil, fmt.Errorf("op %d: insert index %d out of range", i, op.Index)
+			}
+			out = append(out[:op.Index], append([]SnapshotMessage{{Role: op.Role, Content: op.Content}}, out[op.Index:]...)...)
+		case DiffRemove:
+			if op.Index < 0 || op.Index >= len(out) {
+				return nil, fmt.Errorf("op %d: remove index %d out of range", i, op.Index)
+			}
+			out = append(out[:op.Index], out[op.Index+1:]...)
+		case DiffModify:
+			if op.Index < 0 || op.Index >= len(out) {
+				return nil, fmt.Errorf("op %d: modify index %d out of range", i, op.Index)
+			}
+			out[op.Index] = SnapshotMessage{Role: op.Role, Content: op.Content}
+		default:
+			return nil, fmt.Errorf("op %d: unknown op %q", i, op.Op)
+		}
+	}
+	if len(out) != d.Count {
+		return nil, fmt.Errorf("diff expects %d messages after apply, got %d", d.Count, len(out))
+	}
+	return out, nil
+}
+
+// PassSnapshot 是重建出的某个 Pass 执行后的消息快照。
+type PassSnapshot struct {
+	Pass     int               `json:"pass"` // Pass 节点在 Trace 中的序号（从 0 开始）
+	PassName string            `json:"pass_name"`
+	Messages []SnapshotMessage `json:"messages"`
+}
+
+// ReplaySnapshot 按顺序回放 Trace 中各 Pass 记录的差异，重建指定 Pass 执行后的完整消息快照。
+// pass 可以是 Pass 节点序号，也可以是 Pass 名称（同名时取第一个）。
+// 因守卫条件跳过的 Pass 没有差异，其快照与上一个 Pass 相同。
+func ReplaySnapshot(traces []domain.TraceEvent, pass string) (*PassSnapshot, error) {
+	target, byIndex := -1, false
+	if n, err := strconv.Atoi(pass); err == nil {
+		target, byIndex = n, true
+	}
+
+	var snap []SnapshotMessage
+	idx := 0
+	for _, t := range traces {
+		data, err := traceData(t.Data)
+		if err != nil {
+			return nil, err
+		}
+		if isPass, _ := data["is_pass"].(bool); !isPass {
+			continue
+		}
+		name, _ := data["pass_name"].(string)
+
+		if raw, ok := data["diff"]; ok {
+			var diff MessageDiff
+			if err := remarshal(raw, &diff); err != nil {
+				return nil, fmt.Errorf("pass %d (%s): decode diff: %w", idx, name, err)
+			}
+			// 同一条消息上可能附着了多次管线运行的 Trace，基线为空的差异表示新一次运行的开始
+			if diff.BaseCount == 0 {
+				snap = nil
+			}
+			if snap, err = diff.Apply(snap); err != nil {
+				return nil, fmt.Errorf("pass %d (%s): %w", idx, name, err)
+			}
+		}
+
+		if (byIndex && idx == target) || (!byIndex && name == pass) {
+			return &PassSnapshot{Pass: idx, PassName: name, Messages: snap}, nil
+		}
+		idx++
+	}
+	return nil, fmt.Errorf("pass %q not found in %d pass traces", pass, idx)
+}
+
+// traceData 将 TraceEvent.Data 统一为 map：内存中的数据本就是 map，持久化后读回的也是 map。
+func traceData(v interface{}) (map[string]interface{}, error) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, nil
+	}
+	var m map[string]interface{}
+	if v == nil {
+		return m, nil
+	}
+	if err := remarshal(v, &m); err != nil {
+		return nil, fmt.Errorf("decode trace data: %w", err)
+	}
+	return m, nil
+}
+
+func remarshal(in interface{}, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
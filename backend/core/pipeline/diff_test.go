@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func snap(pairs ...string) []SnapshotMessage {
+	out := make([]SnapshotMessage, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		out = append(out, SnapshotMessage{Role: pairs[i], Content: pairs[i+1]})
+	}
+	return out
+}
+
+func TestDiffMessagesRoundTrip(t *testing.T) {
+	history := snap("user", "u1", "assistant", "a1", "user", "u2", "assistant", "a2", "user", "q")
+	tests := []struct {
+		name    string
+		prev    []SnapshotMessage
+		next    []SnapshotMessage
+		wantOps []string
+	}{
+		{"unchanged", history, history, nil},
+		{"empty to full", nil, history, []string{DiffInsert, DiffInsert, DiffInsert, DiffInsert, DiffInsert}},
+		{"full to empty", history, nil, []string{DiffRemove, DiffRemove, DiffRemove, DiffRemove, DiffRemove}},
+		{"prepend system", history, append(snap("system", "sys"), history...), []string{DiffInsert}},
+		{"inject before last", history, append(append(snap(), history[:4]...), append(snap("system", "rag"), history[4])...), []string{DiffInsert}},
+		{"modify middle", history, snap("user", "u1", "assistant", "a1 trimmed", "user", "u2", "assistant", "a2", "user", "q"), []string{DiffModify}},
+		{"drop oldest", history, history[2:], []string{DiffRemove, DiffRemove}},
+		{"summary replaces prefix", history, snap("system", "summary", "assistant", "a2", "user", "q"), []string{DiffRemove, DiffInsert, DiffRemove, DiffRemove}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := DiffMessages(tt.prev, tt.next)
+			var ops []string
+			for _, op := range d.Ops {
+				ops = append(ops, op.Op)
+			}
+			if !reflect.DeepEqual(ops, tt.wantOps) {
+				t.Errorf("ops = %v, want %v", ops, tt.wantOps)
+			}
+			got, err := d.Apply(tt.prev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.next) || (len(got) > 0 && !reflect.DeepEqual(got, tt.next)) {
+				t.Errorf("Apply = %v, want %v", got, tt.next)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var errEmbedderMissing = errors.New("no embedder configured for this pipeline run")
+
+// Embedder 负责将文本转换为向量，通常由记忆服务通过 LLM 网关实现。
+type Embedder interface {
+	GetEmbedding(ctx context.Context, text string, modelID string) ([]float32, error)
+}
+
+// CachingEmbedder 可由 Embedder 实现，额外报告是否命中了其跨请求的缓存。
+type CachingEmbedder interface {
+	GetEmbeddingWithCache(ctx context.Context, text string, modelID string) ([]float32, bool, error)
+}
+
+// EmbeddingStats 汇总单次管线运行中向量请求的缓存情况。
+type EmbeddingStats struct {
+	Hits          int `json:"hits"`           // 命中本次运行内的缓存
+	Misses        int `json:"misses"`         // 需要向 Embedder 请求
+	ServiceHits   int `json:"service_hits"`   // 请求 Embedder 时命中其跨请求缓存
+	ServiceMisses int `json:"service_misses"` // 请求 Embedder 时实际调用了网关
+}
+
+type embeddingKey struct {
+	text    string
+	modelID string
+}
+
+type embeddingEntry struct {
+	done   chan struct{}
+	vector []float32
+	err    error
+}
+
+// embeddingCache 是单次管线运行内按 (text, model) 去重的向量缓存，
+// 并发分支共享同一实例，同一 Key 的并发请求只会触发一次计算。
+type embeddingCache struct {
+	mu      sync.Mutex
+	entries map[embeddingKey]*embeddingEntry
+	stats   EmbeddingStats
+}
+
+func newEmbeddingCache() *embeddingCache {
+	return &embeddingCache{entries: make(map[embeddingKey]*embeddingEntry)}
+}
+
+// Embedding 返回 text 在 modelID 下的向量：同一次运行中相同的 (text, model) 只计算一次。
+// 每次调用都会在当前 Span 中记录一条 EmbeddingCache 事件，标明命中情况。
+func (d *ContextData) Embedding(ctx context.Context, text, modelID string) ([]float32, error) {
+	if d.Embedder == nil {
+		return nil, errEmbedderMissing
+	}
+	if d.embeddings == nil {
+		d.embeddings = newEmbeddingCache()
+	}
+	c := d.embeddings
+	key := embeddingKey{text: text, modelID: modelID}
+
+	c.mu.Lock()
+	entry, hit := c.entries[key]
+	if hit {
+		c.stats.Hits++
+	} else {
+		entry = &embeddingEntry{done: make(chan struct{})}
+		c.entries[key] = entry
+		c.stats.Misses++
+	}
+	c.mu.Unlock()
+
+	result := "hit"
+	if !hit {
+		result = "miss"
+		serviceHit := false
+		if ce, ok := d.Embedder.(CachingEmbedder); ok {
+			entry.vector, serviceHit, entry.err = ce.GetEmbeddingWithCache(ctx, text, modelID)
+		} else {
+			entry.vector, entry.err = d.Embedder.GetEmbedding(ctx, text, modelID)
+		}
+		if serviceHit {
+			result = "service_hit"
+		}
+
+		c.mu.Lock()
+		if serviceHit {
+			c.stats.ServiceHits++
+		} else {
+			c.stats.ServiceMisses++
+		}
+		// 失败结果不缓存，允许后续 Pass 或重试重新计算
+		if entry.err != nil {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+		close(entry.done)
+	} else {
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// 计算方因自身的上下文取消或超时而失败时，该错误不代表本调用方，在自己的上下文下重新计算
+		if isContextErr(entry.err) && ctx.Err() == nil {
+			return d.Embedding(ctx, text, modelID)
+		}
+	}
+
+	d.Event("EmbeddingCache", "Embedding", Attrs{
+		"model_id": modelID,
+		"result":   result,
+	})
+	return entry.vector, entry.err
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// EmbeddingStats 返回本次运行的向量缓存统计。
+func (d *ContextData) EmbeddingStats() EmbeddingStats {
+	if d.embeddings == nil {
+		return EmbeddingStats{}
+	}
+	d.embeddings.mu.Lock()
+	defer d.embeddings.mu.Unlock()
+	return d.embeddings.stats
+}
@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingEmbedder 的第一次调用阻塞到调用方的上下文结束，之后的调用立即返回向量。
+type blockingEmbedder struct {
+	calls   atomic.Int32
+	started chan struct{}
+}
+
+func (e *blockingEmbedder) GetEmbedding(ctx context.Context, _, _ string) ([]float32, error) {
+	if e.calls.Add(1) == 1 {
+		close(e.started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return []float32{1, 2}, nil
+}
+
+func TestEmbeddingWaiterRetriesAfterOwnerContextError(t *testing.T) {
+	emb := &blockingEmbedder{started: make(chan struct{})}
+	data := &ContextData{Embedder: emb}
+
+	ownerCtx, cancel := context.WithCancel(context.Background())
+	ownerErr := make(chan error, 1)
+	go func() {
+		_, err := data.Embedding(ownerCtx, "q", "m")
+		ownerErr <- err
+	}()
+	<-emb.started
+
+	waiter := make(chan []float32, 1)
+	go func() {
+		v, err := data.Embedding(context.Background(), "q", "m")
+		if err != nil {
+			t.Errorf("waiter got error %v, want it to recompute under its own context", err)
+		}
+		waiter <- v
+	}()
+	for data.EmbeddingStats().Hits == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	if err := <-ownerErr; err != context.Canceled {
+		t.Errorf("owner error = %v, want context.Canceled", err)
+	}
+	if v := <-waiter; len(v) != 2 {
+		t.Errorf("waiter vector = %v, want [1 2]", v)
+	}
+	if n := emb.calls.Load(); n != 2 {
+		t.Errorf("embedder calls = %d, want 2", n)
+	}
+}
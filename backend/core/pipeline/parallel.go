@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"context"
+	"context-fabric/backend/core/domain"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// branchResult 保存并发分支在独立副本上的执行结果。
+type branchResult struct {
+	data    *ContextData
+	span    *Span
+	res     stageResult
+	err     error
+	skipped string // 非空表示因守卫条件未满足而跳过
+}
+
+// runParallel 并发执行一组相互独立的 Stage。
+// 每个分支在 ContextData 的独立副本上运行，结束后按声明顺序合并：
+// 新增消息插回各自相对基线的位置（同一位置按声明顺序排列），Meta 仅合并分支实际修改的键，
+// 因此结果与按声明顺序串行执行一致。
+func (p *Pipeline) runParallel(ctx context.Context, group int, stages []Stage, data *ContextData) error {
+	baseMeta := copyMeta(data.Meta)
+	builtins := builtinVars(data)
+
+	results := make([]branchResult, len(stages))
+	var wg sync.WaitGroup
+	for i, stage := range stages {
+		results[i].span = data.Trace.StartSpan(stage.Pass.Name())
+		results[i].span.SetAttr("parallel_group", group)
+		if ok, reason := EvalConditions(stage.When, baseMeta, builtins); !ok {
+			results[i].skipped = reason
+			continue
+		}
+
+		results[i].data = data.fork(baseMeta, results[i].span)
+
+		wg.Add(1)
+		go func(r *branchResult, stage Stage) {
+			defer wg.Done()
+			r.res, r.err = stage.run(ctx, r.data)
+			r.span.End = time.Now()
+		}(&results[i], stage)
+	}
+	wg.Wait()
+
+	// 按声明顺序合并，保证输出与串行执行时的注入顺序一致
+	base := data.Messages
+	inserts := make([]map[int][]domain.Message, len(stages))
+	var errs []error
+	for i, stage := range stages {
+		r := &results[i]
+		if r.skipped != "" {
+			p.finishSkipped(data.Trace, r.span, stage, r.skipped, data)
+			continue
+		}
+
+		if r.err == nil {
+			ins, err := insertionsOf(base, r.data.Messages)
+			if err != nil {
+				// 分支改写了已有消息，无法与其他分支安全合并，按该 Pass 的策略处理
+				r.res.err = err
+				if stage.Policy.OnError == OnErrorSkip {
+					r.res.outcome = OutcomeSkipped
+				} else {
+					r.res.outcome = OutcomeFailed
+					r.err = err
+				}
+			} else {
+				inserts[i] = ins
+				mergeMeta(data.Meta, baseMeta, r.data.Meta)
+			}
+		}
+
+		data.Messages = applyInsertions(base, inserts[:i+1])
+		p.finishComplete(data.Trace, r.span, stage, r.res, data)
+		if err := p.stageError(stage, r.res, r.err); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fork 为并发分支创建独立的数据副本，向量缓存与 Embedder 在分支之间共享，
+// 分支内的事件写入各自的 Span，合并时再按声明顺序记录到主 Trace。
+func (d *ContextData) fork(meta map[string]interface{}, span *Span) *ContextData {
+	return &ContextData{
+		SessionID:  d.SessionID,
+		Messages:   append([]domain.Message(nil), d.Messages...),
+		Meta:       copyMeta(meta),
+		DryRun:     d.DryRun,
+		Trace:      d.Trace,
+		Embedder:   d.Embedder,
+		embeddings: d.embeddings,
+		span:       span,
+	}
+}
+
+// insertionsOf 将分支结果与基线消息对齐，返回按基线位置分组的新增消息。
+// 键 i 表示插入到 base[i] 之前（i == len(base) 表示追加到末尾）。
+// 如果分支删除或改写了基线消息，则返回错误。
+func insertionsOf(base, branch []domain.Message) (map[int][]domain.Message, error) {
+	ins := make(map[int][]domain.Message)
+	i := 0
+	for _, m := range branch {
+		if i < len(base) && sameMessage(base[i], m) {
+			i++
+			continue
+		}
+		ins[i] = append(ins[i], m)
+	}
+	if i < len(base) {
+		return nil, fmt.Errorf("parallel pass modified or removed existing message at index %d; only insertions can be merged", i)
+	}
+	return ins, nil
+}
+
+// applyInsertions 将各分支的新增消息按声明顺序插回基线。
+func applyInsertions(base []domain.Message, inserts []map[int][]domain.Message) []domain.Message {
+	merged := make([]domain.Message, 0, len(base))
+	for pos := 0; pos <= len(base); pos++ {
+		for _, ins := range inserts {
+			merged = append(merged, ins[pos]...)
+		}
+		if pos < len(base) {
+			merged = append(merged, base[pos])
+		}
+	}
+	return merged
+}
+
+func sameMessage(a, b domain.Message) bool {
+	return a.Role == b.Role && a.Content == b.Content && a.Timestamp.Equal(b.Timestamp)
+}
+
+func copyMeta(meta map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(meta))
+	for k, v := range meta {
+		cp[k] = v
+	}
+	return cp
+}
+
+// mergeMeta 将分支新增或修改过的 Meta 键写回主上下文，未改动的键不会覆盖其他分支的结果。
+func mergeMeta(dst, base, branch map[string]interface{}) {
+	for k, v := range branch {
+		if old, ok := base[k]; ok && reflect.DeepEqual(old, v) {
+			continue
+		}
+		dst[k] = v
+	}
+}
@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"context"
+	"context-fabric/backend/core/domain"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// funcPass 以函数实现 Pass，供测试使用。
+type funcPass struct {
+	name string
+	run  func(ctx context.Context, data *ContextData) error
+}
+
+func (p funcPass) Name() string        { return p.name }
+func (p funcPass) Description() string { return p.name }
+func (p funcPass) Run(ctx context.Context, data *ContextData) error {
+	return p.run(ctx, data)
+}
+
+// injectPass 在最后一条消息前插入一条系统消息，并写入给定的 Meta 键值。
+func injectPass(name, content string, meta map[string]interface{}) funcPass {
+	return funcPass{name: name, run: func(_ context.Context, data *ContextData) error {
+		for k, v := range meta {
+			data.Meta[k] = v
+		}
+		msg := domain.Message{Role: domain.RoleSystem, Content: content, Timestamp: time.Unix(1, 0)}
+		idx := len(data.Messages) - 1
+		data.Messages = append(data.Messages[:idx:idx], msg, data.Messages[idx])
+		return nil
+	}}
+}
+
+func parallelPipeline(t *testing.T, policies []Policy, passes ...Pass) *Pipeline {
+	t.Helper()
+	stages := make([]Stage, len(passes))
+	for i, pass := range passes {
+		stage, err := NewStage(pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policies != nil {
+			stage.Policy = policies[i]
+		}
+		stages[i] = stage
+	}
+	return NewNamedPipeline("test", Parallel(stages...))
+}
+
+func newData() *ContextData {
+	return &ContextData{
+		Messages: []domain.Message{
+			{Role: domain.RoleUser, Content: "hi", Timestamp: time.Unix(0, 0)},
+			{Role: domain.RoleUser, Content: "question", Timestamp: time.Unix(0, 0)},
+		},
+		Meta: map[string]interface{}{"shared": "base", "untouched": 1},
+	}
+}
+
+func contents(msgs []domain.Message) string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.Content
+	}
+	return strings.Join(out, ",")
+}
+
+func TestParallelSameMetaKeyLaterBranchWins(t *testing.T) {
+	p := parallelPipeline(t, nil,
+		injectPass("A", "a", map[string]interface{}{"shared": "from-a", "only_a": true}),
+		injectPass("B", "b", map[string]interface{}{"shared": "from-b", "untouched": 1}),
+	)
+	data := newData()
+	if err := p.Execute(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	// 两个分支都修改了 shared 时按声明顺序合并，后声明的分支生效
+	if got := data.Meta["shared"]; got != "from-b" {
+		t.Errorf("shared = %v, want from-b", got)
+	}
+	if got := data.Meta["only_a"]; got != true {
+		t.Errorf("only_a = %v, want true", got)
+	}
+	if got := data.Meta["untouched"]; got != 1 {
+		t.Errorf("untouched = %v, want 1", got)
+	}
+	if got, want := contents(data.Messages), "hi,a,b,question"; got != want {
+		t.Errorf("messages = %s, want %s", got, want)
+	}
+}
+
+func TestParallelUnchangedKeyDoesNotOverrideEarlierBranch(t *testing.T) {
+	p := parallelPipeline(t, nil,
+		injectPass("A", "a", map[string]interface{}{"shared": "from-a"}),
+		injectPass("B", "b", map[string]interface{}{"shared": "base"}), // 写回基线值视为未修改
+	)
+	data := newData()
+	if err := p.Execute(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	if got := data.Meta["shared"]; got != "from-a" {
+		t.Errorf("shared = %v, want from-a", got)
+	}
+}
+
+func TestParallelErroringBranch(t *testing.T) {
+	failing := funcPass{name: "Broken", run: func(_ context.Context, data *ContextData) error {
+		data.Meta["shared"] = "partial"
+		data.Meta["broken_key"] = true
+		data.Messages = append(data.Messages, domain.Message{Role: domain.RoleSystem, Content: "partial"})
+		return errors.New("boom")
+	}}
+
+	tests := []struct {
+		name    string
+		onError ErrorMode
+		wantErr bool
+	}{
+		{"skip", OnErrorSkip, false},
+		{"fail", OnErrorFail, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parallelPipeline(t, []Policy{{}, {OnError: tt.onError}},
+				injectPass("A", "a", map[string]interface{}{"only_a": true}),
+				failing,
+			)
+			data := newData()
+			err := p.Execute(context.Background(), data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), "pass Broken failed") {
+				t.Errorf("error = %v, want it to name the failing pass", err)
+			}
+
+			// 失败分支的消息与 Meta 修改全部丢弃，其他分支的结果照常合并
+			if got, want := contents(data.Messages), "hi,a,question"; got != want {
+				t.Errorf("messages = %s, want %s", got, want)
+			}
+			if got := data.Meta["shared"]; got != "base" {
+				t.Errorf("shared = %v, want base", got)
+			}
+			if _, ok := data.Meta["broken_key"]; ok {
+				t.Error("broken_key leaked from the failed branch")
+			}
+			if got := data.Meta["only_a"]; got != true {
+				t.Errorf("only_a = %v, want true", got)
+			}
+		})
+	}
+}
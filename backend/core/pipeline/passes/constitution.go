@@ -4,24 +4,72 @@ import (
 	"context"
 	"context-fabric/backend/core/domain"
 	"context-fabric/backend/core/pipeline"
+	"context-fabric/backend/core/prompts"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 )
 
+// ConstitutionConfig 是 ConstitutionPass 的可配置参数。
+type ConstitutionConfig struct {
+	TopK       int          `json:"top_k"`      // 每层（长期记忆、近期事实）注入的条数
+	Candidates int          `json:"candidates"` // 开启重排时每层召回的候选数，0 表示 top_k 的 4 倍
+	Rerank     RerankConfig `json:"rerank"`     // 注入前的重排、阈值过滤与多样性挑选，各层分别处理
+}
+
+func (c *ConstitutionConfig) Validate() error {
+	var errs []error
+	if c.TopK <= 0 {
+		errs = append(errs, fmt.Errorf("top_k must be positive, got %d", c.TopK))
+	}
+	if c.Candidates < 0 {
+		errs = append(errs, fmt.Errorf("candidates must not be negative, got %d", c.Candidates))
+	}
+	if err := c.Rerank.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("rerank: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func init() {
+	pipeline.RegisterPass("Constitution", "注入长期记忆与近期事实 (DEMA)", ConstitutionConfig{TopK: 3, Rerank: defaultRerank},
+		func(cfg ConstitutionConfig, deps pipeline.Dependencies) (pipeline.Pass, error) {
+			return NewConstitutionPass(deps.Memory).
+				WithTopK(cfg.TopK, cfg.Candidates).
+				WithRerank(cfg.Rerank, deps.Reranker), nil
+		})
+}
+
+// ConstitutionPass 从 DEMA 记忆系统中检索与当前问题相关的长期记忆和近期事实，并作为系统消息注入。
 type ConstitutionPass struct {
 	memorySvc interface {
-		GetEmbedding(ctx context.Context, text string, modelID string) ([]float32, error)
-		Retrieve(ctx context.Context, vector []float32) ([]domain.SharedMemory, []domain.StagingFact, error)
+		Retrieve(ctx context.Context, vector []float32, limit int, withVector bool) ([]domain.SharedMemory, []domain.StagingFact, error)
 	}
+	topK       int
+	candidates int
+	rerank     reranker
 }
 
 func NewConstitutionPass(svc interface {
-	GetEmbedding(ctx context.Context, text string, modelID string) ([]float32, error)
-	Retrieve(ctx context.Context, vector []float32) ([]domain.SharedMemory, []domain.StagingFact, error)
+	Retrieve(ctx context.Context, vector []float32, limit int, withVector bool) ([]domain.SharedMemory, []domain.StagingFact, error)
 }) *ConstitutionPass {
-	return &ConstitutionPass{memorySvc: svc}
+	return &ConstitutionPass{memorySvc: svc, topK: 3, rerank: reranker{cfg: defaultRerank}}
+}
+
+// WithTopK 设置每层注入的条数与开启重排时的候选数。
+func (p *ConstitutionPass) WithTopK(k, candidates int) *ConstitutionPass {
+	if k > 0 {
+		p.topK = k
+	}
+	p.candidates = candidates
+	return p
+}
+
+// WithRerank 设置注入前的重排步骤，client 为空时跳过交叉编码器重排。
+func (p *ConstitutionPass) WithRerank(cfg RerankConfig, client pipeline.Reranker) *ConstitutionPass {
+	p.rerank = reranker{cfg: cfg, client: client}
+	return p
 }
 
 func (p *ConstitutionPass) Name() string {
@@ -32,69 +80,83 @@ func (p *ConstitutionPass) Description() string {
 	return "注入长期记忆与近期事实 (DEMA)"
 }
 
+// DefaultPolicy 记忆检索失败时降级为不注入记忆，不影响主流程。
+func (p *ConstitutionPass) DefaultPolicy() pipeline.Policy {
+	return pipeline.Policy{OnError: pipeline.OnErrorSkip}
+}
+
 func (p *ConstitutionPass) Run(ctx context.Context, data *pipeline.ContextData) error {
 	if p.memorySvc == nil {
 		return nil
 	}
 
 	// 1. 提取 Query
-	var userQuery string
-	for i := len(data.Messages) - 1; i >= 0; i-- {
-		if data.Messages[i].Role == domain.RoleUser {
-			userQuery = data.Messages[i].Content
-			break
-		}
-	}
+	userQuery := retrievalQuery(data)
 	if userQuery == "" {
 		return nil
 	}
 
 	log.Printf("[Constitution] Building context for query: %s", userQuery)
 
-	// 2. 获取向量
-	embeddingModel, _ := data.Meta["rag_embedding_model"].(string)
-	vector, err := p.memorySvc.GetEmbedding(ctx, userQuery, embeddingModel)
+	// 2. 获取向量（复用本次运行内的向量缓存）
+	vector, err := data.Embedding(ctx, userQuery, embeddingModelOf(data, ""))
 	if err != nil {
 		log.Printf("[Constitution] ERROR: Failed to get embedding: %v", err)
-		return nil // 允许失败，降级处理
+		return fmt.Errorf("embedding failed: %w", err)
 	}
 
-	// 3. 检索
-	l1, l2, err := p.memorySvc.Retrieve(ctx, vector)
+	// 3. 检索并按层重排
+	limit := p.topK
+	if p.rerank.cfg.enabled() {
+		limit = p.candidates
+		if limit <= 0 {
+			limit = p.topK * 4
+		}
+	}
+	l1, l2, err := p.memorySvc.Retrieve(ctx, vector, limit, p.rerank.cfg.MMR)
 	if err != nil {
 		log.Printf("[Constitution] ERROR: Retrieval failed: %v", err)
-		return nil
+		return fmt.Errorf("retrieval failed: %w", err)
 	}
+	l1 = rerankItems(ctx, data, p.rerank, "Memories", userQuery, l1, p.topK, func(m domain.SharedMemory) domain.RetrievedChunk {
+		return domain.RetrievedChunk{Content: m.Content, Score: float64(m.Score), VectorScore: float64(m.Score), Vector: m.Vector}
+	})
+	l2 = rerankItems(ctx, data, p.rerank, "Facts", userQuery, l2, p.topK, func(f domain.StagingFact) domain.RetrievedChunk {
+		return domain.RetrievedChunk{Content: f.Content, Score: float64(f.Score), VectorScore: float64(f.Score), Vector: f.Vector}
+	})
 
 	log.Printf("[Constitution] Retrieved %d long-term memories and %d recent facts", len(l1), len(l2))
+	data.Meta["memory_count"] = len(l1)
+	data.Meta["fact_count"] = len(l2)
 
-	// 4. 构建注入文本
-	var sb strings.Builder
-	if len(l1) > 0 {
-		sb.WriteString("### 核心事实与偏好 (长期)\n")
-		for _, m := range l1 {
-			sb.WriteString(fmt.Sprintf("- %s\n", m.Content))
-		}
+	// 4. 按请求语言渲染注入文本，并在 Span 中记录实际注入的条目，便于 explain 排查
+	if len(l1) == 0 && len(l2) == 0 {
+		return nil
 	}
-	if len(l2) > 0 {
-		if sb.Len() > 0 {
-			sb.WriteString("\n")
-		}
-		sb.WriteString("### 相关近期事件 (暂存)\n")
-		for _, f := range l2 {
-			sb.WriteString(fmt.Sprintf("- %s\n", f.Content))
-		}
+	var in prompts.MemoryData
+	for _, m := range l1 {
+		in.Memories = append(in.Memories, m.Content)
 	}
-
-	if sb.Len() == 0 {
-		return nil
+	for _, f := range l2 {
+		in.Facts = append(in.Facts, f.Content)
+	}
+	if len(in.Memories) > 0 {
+		data.SetAttr("injected_memories", in.Memories)
+	}
+	if len(in.Facts) > 0 {
+		data.SetAttr("injected_facts", in.Facts)
+	}
+	content, err := prompts.Inject(localeOf(data), prompts.InjectMemory, in)
+	if err != nil {
+		return err
 	}
 
 	// 5. 注入作为系统消息
 	systemMsg := domain.Message{
 		Role:      domain.RoleSystem,
-		Content:   "这是从你的长期记忆和近期交互中提取的背景信息，请在回复时参考：\n\n" + sb.String(),
+		Content:   content,
 		Timestamp: time.Now(),
+		Meta:      map[string]interface{}{domain.MetaLayer: domain.LayerMemory},
 	}
 
 	// 插入到最后一条消息之前
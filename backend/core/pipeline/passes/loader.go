@@ -2,18 +2,28 @@ package passes
 
 import (
 	"context"
-	"context-fabric/backend/core/history"
 	"context-fabric/backend/core/pipeline"
+	"errors"
 	"fmt"
 )
 
+func init() {
+	pipeline.RegisterPass("HistoryLoader", "加载历史会话", struct{}{},
+		func(_ struct{}, deps pipeline.Dependencies) (pipeline.Pass, error) {
+			if deps.History == nil {
+				return nil, errors.New("history store is not configured")
+			}
+			return NewHistoryLoader(deps.History), nil
+		})
+}
+
 // HistoryLoader 负责从持久化存储中加载指定会话的历史消息。
 type HistoryLoader struct {
-	historyService *history.Service
+	historyService pipeline.HistoryStore
 }
 
 // NewHistoryLoader 创建一个 HistoryLoader 实例。
-func NewHistoryLoader(h *history.Service) *HistoryLoader {
+func NewHistoryLoader(h pipeline.HistoryStore) *HistoryLoader {
 	return &HistoryLoader{
 		historyService: h,
 	}
@@ -35,21 +45,18 @@ func (p *HistoryLoader) Run(ctx context.Context, data *pipeline.ContextData) err
 		return fmt.Errorf("failed to load session %s: %w", data.SessionID, err)
 	}
 
-	// 初始化管线中的消息列表
-	data.Messages = session.Messages
+	// 初始化管线中的消息列表，未持久化的消息追加在历史之后（限定容量以免改写会话的底层数组）
+	n := len(session.Messages)
+	data.Messages = append(session.Messages[:n:n], data.Pending...)
 
 	// 将会话的元数据注入到共享上下文
 	data.Meta["app_id"] = session.AppID
+	data.Meta["session_name"] = session.Name
 	data.Meta["created_at"] = session.CreatedAt
 
 	// 记录具体的加载情况
-	data.Traces = append(data.Traces, map[string]interface{}{
-		"source": "HistoryLoader",
-		"target": "ContextData",
-		"action": "Loaded",
-		"data": map[string]interface{}{
-			"original_count": len(session.Messages),
-		},
+	data.Event("ContextData", "Loaded", pipeline.Attrs{
+		"original_count": len(session.Messages),
 	})
 
 	return nil
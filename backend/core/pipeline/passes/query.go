@@ -0,0 +1,43 @@
+package passes
+
+import (
+	"context-fabric/backend/core/domain"
+	"context-fabric/backend/core/pipeline"
+	"context-fabric/backend/core/util"
+)
+
+// lastUserQuery 返回消息列表中最后一条用户消息的内容，作为检索查询。
+func lastUserQuery(msgs []domain.Message) string {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == domain.RoleUser {
+			return msgs[i].Content
+		}
+	}
+	return ""
+}
+
+// retrievalQuery 返回检索使用的查询：QueryRewrite 写入的 retrieval_query 优先，否则为最后一条用户消息。
+func retrievalQuery(data *pipeline.ContextData) string {
+	if q, _ := data.Meta[MetaRetrievalQuery].(string); q != "" {
+		return q
+	}
+	return lastUserQuery(data.Messages)
+}
+
+// embeddingModelOf 返回本次请求使用的向量模型：优先使用请求传入的 rag_embedding_model，
+// 否则依次回退到 fallback 与 RAG_EMBEDDING_MODEL 环境变量，保证各 Pass 命中同一份缓存。
+func embeddingModelOf(data *pipeline.ContextData, fallback string) string {
+	if model, _ := data.Meta["rag_embedding_model"].(string); model != "" {
+		return model
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return util.GetEnv("RAG_EMBEDDING_MODEL", "text-embedding-3-small")
+}
+
+// localeOf 返回本次请求的注入语言，由 Engine 按请求或 AppID 写入 Meta 的 locale。
+func localeOf(data *pipeline.ContextData) string {
+	locale, _ := data.Meta["locale"].(string)
+	return locale
+}
@@ -0,0 +1,62 @@
+package passes
+
+import (
+	"context"
+	"context-fabric/backend/core/pipeline"
+	"fmt"
+	"log"
+)
+
+// QueryEmbeddingConfig 是 QueryEmbeddingPass 的可配置参数。
+type QueryEmbeddingConfig struct {
+	EmbeddingModel string `json:"embedding_model"` // 请求未指定模型时使用，留空沿用环境变量
+}
+
+func init() {
+	pipeline.RegisterPass("QueryEmbedding", "计算查询向量 (共享缓存)", QueryEmbeddingConfig{},
+		func(cfg QueryEmbeddingConfig, _ pipeline.Dependencies) (pipeline.Pass, error) {
+			return NewQueryEmbeddingPass(cfg.EmbeddingModel), nil
+		})
+}
+
+// QueryEmbeddingPass 在检索类 Pass 之前预先计算检索查询（改写后的查询或最后一条用户提问）的向量。
+// 结果写入本次运行的向量缓存，后续的 RAGPass、ConstitutionPass 直接复用，避免重复调用网关。
+type QueryEmbeddingPass struct {
+	defaultModelID string
+}
+
+// NewQueryEmbeddingPass 创建查询向量预计算处理器。
+func NewQueryEmbeddingPass(defaultModelID string) *QueryEmbeddingPass {
+	return &QueryEmbeddingPass{defaultModelID: defaultModelID}
+}
+
+func (p *QueryEmbeddingPass) Name() string {
+	return "QueryEmbedding"
+}
+
+func (p *QueryEmbeddingPass) Description() string {
+	return "计算查询向量 (共享缓存)"
+}
+
+// DefaultPolicy 预计算失败不影响主流程，后续 Pass 会自行重试获取向量。
+func (p *QueryEmbeddingPass) DefaultPolicy() pipeline.Policy {
+	return pipeline.Policy{OnError: pipeline.OnErrorSkip}
+}
+
+func (p *QueryEmbeddingPass) Run(ctx context.Context, data *pipeline.ContextData) error {
+	if data.Embedder == nil {
+		return nil
+	}
+	query := retrievalQuery(data)
+	if query == "" {
+		return nil
+	}
+
+	model := embeddingModelOf(data, p.defaultModelID)
+	if _, err := data.Embedding(ctx, query, model); err != nil {
+		log.Printf("[QueryEmbedding] Embedding Error - %v", err)
+		return fmt.Errorf("embedding failed: %w", err)
+	}
+	data.Meta["query_embedding_model"] = model
+	return nil
+}
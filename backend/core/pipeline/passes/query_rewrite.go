@@ -0,0 +1,230 @@
+package passes
+
+import (
+	"context"
+	"context-fabric/backend/core/documents"
+	"context-fabric/backend/core/domain"
+	"context-fabric/backend/core/pipeline"
+	"context-fabric/backend/core/prompts"
+	"context-fabric/backend/core/util"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// MetaRetrievalQuery 是 QueryRewrite 写入的独立检索查询，检索类 Pass 优先使用。
+const MetaRetrievalQuery = "retrieval_query"
+
+// 查询改写方式，记录在 Span 的 rewrite_method 属性中。
+const (
+	RewriteNone      = "none"      // 无可参考的历史或提问已足够完整，沿用原始提问
+	RewriteHeuristic = "heuristic" // 将上一轮用户提问与追问拼接
+	RewriteLLM       = "llm"       // 经 LLM 网关改写
+)
+
+// rewriteHistoryRunes 是交给 LLM 的每条历史消息的最大字符数，避免长回复挤占改写提示。
+const rewriteHistoryRunes = 500
+
+// QueryRewriteConfig 是 QueryRewritePass 的可配置参数。
+type QueryRewriteConfig struct {
+	Model           string `json:"model"`             // 改写所用的模型，留空时仅做启发式拼接
+	HistoryMessages int    `json:"history_messages"`  // 参考的最近历史消息数（不含当前提问）
+	ShortQueryTerms int    `json:"short_query_terms"` // 启发式：词项数不超过该值的提问视为追问，默认 0 即不做启发式拼接
+	CacheSize       int    `json:"cache_size"`        // 跨请求缓存的 LLM 改写结果数，0 表示不缓存
+}
+
+func (c *QueryRewriteConfig) Validate() error {
+	var errs []error
+	if c.HistoryMessages <= 0 {
+		errs = append(errs, fmt.Errorf("history_messages must be positive, got %d", c.HistoryMessages))
+	}
+	if c.ShortQueryTerms < 0 {
+		errs = append(errs, fmt.Errorf("short_query_terms must not be negative, got %d", c.ShortQueryTerms))
+	}
+	if c.CacheSize < 0 {
+		errs = append(errs, fmt.Errorf("cache_size must not be negative, got %d", c.CacheSize))
+	}
+	// 两者都未配置时 Pass 不会改写任何查询
+	if c.Model == "" && c.ShortQueryTerms == 0 {
+		errs = append(errs, errors.New("model or short_query_terms must be set"))
+	}
+	return errors.Join(errs...)
+}
+
+func init() {
+	pipeline.RegisterPass("QueryRewrite", "结合对话改写检索查询",
+		QueryRewriteConfig{HistoryMessages: 6, CacheSize: 512},
+		func(cfg QueryRewriteConfig, deps pipeline.Dependencies) (pipeline.Pass, error) {
+			if cfg.Model != "" && deps.LLMServiceURL == "" {
+				return nil, errors.New("llm service url is not configured")
+			}
+			return NewQueryRewritePass(cfg.HistoryMessages, cfg.ShortQueryTerms).
+				WithLLM(deps.LLMServiceURL, cfg.Model, cfg.CacheSize), nil
+		})
+}
+
+// QueryRewritePass 在检索前将用户的最新提问改写为不依赖上下文的独立查询，写入 Meta 的 retrieval_query，
+// 使 "那第二个呢？" 这类追问也能检索到相关内容。配置了模型时经 LLM 网关改写，结果按对话内容的哈希跨请求缓存；
+// 否则（或 LLM 调用失败时）对较短的追问拼接上一轮用户提问。
+type QueryRewritePass struct {
+	historyMessages int
+	shortQueryTerms int
+	llmServiceURL   string
+	model           string
+	cache           *util.LRU[string] // 跨请求共享的改写结果缓存
+}
+
+// NewQueryRewritePass 创建仅做启发式拼接的查询改写处理器。
+func NewQueryRewritePass(historyMessages, shortQueryTerms int) *QueryRewritePass {
+	return &QueryRewritePass{historyMessages: historyMessages, shortQueryTerms: shortQueryTerms}
+}
+
+// WithLLM 开启 LLM 改写，cacheSize 为跨请求缓存的条目数；model 为空时保持启发式拼接。
+func (p *QueryRewritePass) WithLLM(url, model string, cacheSize int) *QueryRewritePass {
+	p.llmServiceURL = url
+	p.model = model
+	p.cache = util.NewLRU[string](cacheSize)
+	return p
+}
+
+func (p *QueryRewritePass) Name() string {
+	return "QueryRewrite"
+}
+
+func (p *QueryRewritePass) Description() string {
+	return "结合对话改写检索查询"
+}
+
+// DefaultPolicy 改写失败时检索类 Pass 直接使用原始提问。
+func (p *QueryRewritePass) DefaultPolicy() pipeline.Policy {
+	return pipeline.Policy{OnError: pipeline.OnErrorSkip}
+}
+
+func (p *QueryRewritePass) Run(ctx context.Context, data *pipeline.ContextData) error {
+	history, query := p.window(data.Messages)
+	if query == "" {
+		return nil
+	}
+
+	rewritten, method := query, RewriteNone
+	if len(history) > 0 {
+		if p.model != "" {
+			var err error
+			if rewritten, err = p.rewriteLLM(ctx, data, history, query); err == nil {
+				method = RewriteLLM
+			} else {
+				log.Printf("[QueryRewrite] LLM rewrite failed, falling back to heuristic - %v", err)
+				data.Event("LLMService", "RewriteFailed", pipeline.Attrs{"error": err.Error()})
+			}
+		}
+		if method == RewriteNone {
+			rewritten = query
+			if condensed := p.condense(history, query); condensed != query {
+				rewritten, method = condensed, RewriteHeuristic
+			}
+		}
+	}
+
+	data.Meta[MetaRetrievalQuery] = rewritten
+	data.SetAttr("original_query", query)
+	data.SetAttr("retrieval_query", rewritten)
+	data.SetAttr("rewrite_method", method)
+	return nil
+}
+
+// window 返回当前提问与其之前至多 historyMessages 条对话历史，注入类消息不参与改写。
+func (p *QueryRewritePass) window(msgs []domain.Message) ([]domain.Message, string) {
+	var history []domain.Message
+	for _, i := range historyIndices(msgs) {
+		history = append(history, msgs[i])
+	}
+	last := len(history) - 1
+	for last >= 0 && history[last].Role != domain.RoleUser {
+		last--
+	}
+	if last < 0 {
+		return nil, ""
+	}
+	query := history[last].Content
+	history = history[:last]
+	if len(history) > p.historyMessages {
+		history = history[len(history)-p.historyMessages:]
+	}
+	return history, query
+}
+
+// condense 是启发式改写：词项较少的提问视为追问，前面拼接最近一条用户提问；shortQueryTerms 为 0 时不改写。
+func (p *QueryRewritePass) condense(history []domain.Message, query string) string {
+	if p.shortQueryTerms <= 0 || len(documents.Terms(query)) > p.shortQueryTerms {
+		return query
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == domain.RoleUser && strings.TrimSpace(history[i].Content) != "" {
+			return history[i].Content + "\n" + query
+		}
+	}
+	return query
+}
+
+// rewriteLLM 经 LLM 网关改写查询，相同模型、语言与对话内容的结果直接从缓存返回。
+func (p *QueryRewritePass) rewriteLLM(ctx context.Context, data *pipeline.ContextData, history []domain.Message, query string) (string, error) {
+	locale := localeOf(data)
+	var sb strings.Builder
+	for _, m := range history {
+		sb.WriteString(fmt.Sprintf("%s: %s\n", m.Role, excerpt(m.Content, rewriteHistoryRunes)))
+	}
+	serialized := sb.String()
+
+	key := historyHash(p.model, locale, serialized, query)
+	data.SetAttr("history_hash", key[:16])
+	if rewritten, ok := p.cache.Get(key); ok {
+		data.Event("RewriteCache", "Hit", pipeline.Attrs{"history_hash": key[:16]})
+		return rewritten, nil
+	}
+	data.Event("RewriteCache", "Miss", pipeline.Attrs{"history_hash": key[:16]})
+
+	prompt, err := prompts.Inject(locale, prompts.InjectQueryRewrite, prompts.QueryRewriteData{History: serialized, Query: query})
+	if err != nil {
+		return "", err
+	}
+	content, err := chatCompletion(ctx, p.llmServiceURL, p.model, prompt, 15*time.Second)
+	if err != nil {
+		return "", err
+	}
+	rewritten := cleanRewrite(content)
+	if rewritten == "" {
+		return "", errors.New("LLM 返回的改写查询为空")
+	}
+	p.cache.Put(key, rewritten)
+	data.Event("LLMService", "Rewritten", pipeline.Attrs{"model": p.model})
+	return rewritten, nil
+}
+
+// cleanRewrite 取模型输出的首个非空行，并去掉常见的引号与 "Query:" 前缀。
+func cleanRewrite(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		for _, prefix := range []string{"Query:", "query:", "查询：", "查询:", "クエリ："} {
+			line = strings.TrimSpace(strings.TrimPrefix(line, prefix))
+		}
+		return strings.Trim(line, "\"'“”「」")
+	}
+	return ""
+}
+
+// historyHash 是改写缓存的 Key，覆盖模型、语言、参与改写的对话与当前提问。
+func historyHash(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
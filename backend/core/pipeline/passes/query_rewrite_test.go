@@ -0,0 +1,34 @@
+package passes
+
+import (
+	"context-fabric/backend/core/domain"
+	"testing"
+)
+
+func TestQueryRewriteCondense(t *testing.T) {
+	history := []domain.Message{
+		{Role: domain.RoleUser, Content: "how do I rotate API keys"},
+		{Role: domain.RoleAssistant, Content: "Use the admin console."},
+	}
+	tests := []struct {
+		name  string
+		terms int
+		query string
+		want  string
+	}{
+		{"opt-in by default", 0, "and the second?", "and the second?"},
+		{"no terms is not condensed by default", 0, "??", "??"},
+		{"short follow-up", 4, "and the second?", "how do I rotate API keys\nand the second?"},
+		{"long question kept", 2, "what is the retention policy for audit logs", "what is the retention policy for audit logs"},
+	}
+	for _, tt := range tests {
+		p := NewQueryRewritePass(6, tt.terms)
+		if got := p.condense(history, tt.query); got != tt.want {
+			t.Errorf("%s: condense(%q) = %q, want %q", tt.name, tt.query, got, tt.want)
+		}
+	}
+
+	if err := (&QueryRewriteConfig{HistoryMessages: 6}).Validate(); err == nil {
+		t.Error("Validate accepted a config without model or short_query_terms")
+	}
+}
@@ -5,33 +5,175 @@ import (
 	"context"
 	"context-fabric/backend/core/domain"
 	"context-fabric/backend/core/pipeline"
+	"context-fabric/backend/core/prompts"
 	"context-fabric/backend/core/util"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
 )
 
+// RAGConfig 是 RAGPass 的可配置参数，字符串参数留空时沿用环境变量。
+type RAGConfig struct {
+	TopK           int          `json:"top_k"`
+	Collection     string       `json:"collection"`
+	EmbeddingModel string       `json:"embedding_model"`
+	Mode           string       `json:"mode"`           // vector / lexical / hybrid，hybrid 融合向量与关键词（BM25）检索
+	Candidates     int          `json:"candidates"`     // hybrid 模式或开启重排时每个检索器召回的候选数，0 表示 top_k 的 4 倍
+	VectorWeight   float64      `json:"vector_weight"`  // RRF 融合中向量检索的权重
+	LexicalWeight  float64      `json:"lexical_weight"` // RRF 融合中关键词检索的权重
+	RRFK           int          `json:"rrf_k"`          // RRF 平滑常数，越大名次差距的影响越小
+	Rerank         RerankConfig `json:"rerank"`         // 注入前的重排、阈值过滤与多样性挑选
+
+	Collections        []domain.RAGCollection `json:"collections"`         // 多集合检索，结果按加权 RRF 合并；与 collection 二选一
+	AllowedCollections []string               `json:"allowed_collections"` // 请求在已配置的集合之外还可指定的集合
+}
+
+func (c *RAGConfig) Validate() error {
+	var errs []error
+	if c.TopK <= 0 {
+		errs = append(errs, fmt.Errorf("top_k must be positive, got %d", c.TopK))
+	}
+	switch c.Mode {
+	case RetrievalVector, RetrievalLexical, RetrievalHybrid:
+	default:
+		errs = append(errs, fmt.Errorf("mode must be one of %q, %q, %q, got %q", RetrievalVector, RetrievalLexical, RetrievalHybrid, c.Mode))
+	}
+	if c.Candidates < 0 {
+		errs = append(errs, fmt.Errorf("candidates must not be negative, got %d", c.Candidates))
+	}
+	if c.VectorWeight < 0 || c.LexicalWeight < 0 {
+		errs = append(errs, fmt.Errorf("vector_weight and lexical_weight must not be negative, got %v and %v", c.VectorWeight, c.LexicalWeight))
+	} else if c.Mode == RetrievalHybrid && c.VectorWeight+c.LexicalWeight == 0 {
+		errs = append(errs, errors.New("vector_weight and lexical_weight must not both be zero in hybrid mode"))
+	}
+	if c.RRFK <= 0 {
+		errs = append(errs, fmt.Errorf("rrf_k must be positive, got %d", c.RRFK))
+	}
+	if err := c.Rerank.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("rerank: %w", err))
+	}
+	// lexical / hybrid 的得分是 BM25 或 RRF 口径，没有可用的绝对阈值
+	if c.Rerank.MinScore != 0 && c.Rerank.Model == "" && c.Mode != RetrievalVector {
+		errs = append(errs, fmt.Errorf("rerank.min_score requires rerank.model in %s mode", c.Mode))
+	}
+	if c.Collection != "" && len(c.Collections) > 0 {
+		errs = append(errs, errors.New("collection and collections are mutually exclusive"))
+	}
+	for i, coll := range c.Collections {
+		if err := validateCollection(coll); err != nil {
+			errs = append(errs, fmt.Errorf("collections[%d]: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func init() {
+	pipeline.RegisterPass("RAGPass", "检索增强生成 (RAG)",
+		RAGConfig{TopK: 3, Mode: RetrievalHybrid, VectorWeight: 1, LexicalWeight: 1, RRFK: 60, Rerank: defaultRerank},
+		func(cfg RAGConfig, deps pipeline.Dependencies) (pipeline.Pass, error) {
+			if cfg.Mode == RetrievalLexical && deps.Documents == nil {
+				return nil, errors.New("lexical mode requires a document index")
+			}
+			p := NewRAGPass().WithTopK(cfg.TopK).
+				WithHybrid(cfg.Mode, deps.Documents, cfg.Candidates, cfg.VectorWeight, cfg.LexicalWeight, cfg.RRFK).
+				WithRerank(cfg.Rerank, deps.Reranker).
+				WithCollections(cfg.Collections, cfg.AllowedCollections)
+			if cfg.Collection != "" {
+				p.collectionName = cfg.Collection
+			}
+			if cfg.EmbeddingModel != "" {
+				p.defaultModelID = cfg.EmbeddingModel
+			}
+			return p, nil
+		})
+}
+
 // RAGPass 实现了检索增强生成逻辑，支持从向量数据库获取背景知识。
+// 混合模式下同时查询本地 BM25 索引，以 RRF 融合两路结果，弥补向量检索对精确标识符不敏感的问题。
+// 请求或 Profile 指定多个集合时逐个检索，再按各集合的权重以 RRF 合并。
 type RAGPass struct {
 	qdrantURL      string
 	collectionName string
-	embeddingURL   string
 	defaultModelID string
 	topK           int
+	mode           string
+	index          pipeline.DocumentIndex
+	candidates     int
+	vectorWeight   float64
+	lexicalWeight  float64
+	rrfK           int
+	rerank         reranker
+	collections    []domain.RAGCollection
+	allowed        map[string]bool
 }
 
-// NewRAGPass 基于环境变量初始化 RAG 处理器。
+// NewRAGPass 基于环境变量初始化 RAG 处理器，默认与注册的配置一致使用 hybrid 模式；未设置关键词索引时退化为仅向量检索。
 func NewRAGPass() *RAGPass {
 	return &RAGPass{
 		qdrantURL:      util.GetEnv("QDRANT_URL", "http://localhost:6333"),
 		collectionName: util.GetEnv("QDRANT_COLLECTION", "documents"),
-		embeddingURL:   util.GetEnv("LLM_SERVICE_URL", "http://localhost:8000") + "/v1/embeddings",
 		defaultModelID: util.GetEnv("RAG_EMBEDDING_MODEL", "text-embedding-3-small"),
 		topK:           3,
+		mode:           RetrievalHybrid,
+		vectorWeight:   1,
+		lexicalWeight:  1,
+		rrfK:           60,
+		rerank:         reranker{cfg: defaultRerank},
+	}
+}
+
+// WithTopK 覆盖单次检索返回的文档数量。
+func (p *RAGPass) WithTopK(k int) *RAGPass {
+	if k > 0 {
+		p.topK = k
+	}
+	return p
+}
+
+// WithHybrid 设置检索模式与关键词索引；index 为空时 hybrid 模式退化为仅向量检索。
+// candidates 为 0 时每路召回 top_k 的 4 倍。
+func (p *RAGPass) WithHybrid(mode string, index pipeline.DocumentIndex, candidates int, vectorWeight, lexicalWeight float64, rrfK int) *RAGPass {
+	p.mode = mode
+	p.index = index
+	p.candidates = candidates
+	p.vectorWeight = vectorWeight
+	p.lexicalWeight = lexicalWeight
+	p.rrfK = rrfK
+	return p
+}
+
+// WithRerank 设置注入前的重排步骤，client 为空时跳过交叉编码器重排。
+func (p *RAGPass) WithRerank(cfg RerankConfig, client pipeline.Reranker) *RAGPass {
+	p.rerank = reranker{cfg: cfg, client: client}
+	return p
+}
+
+// WithCollections 设置默认检索的集合列表与请求额外可指定的集合；collections 为空时使用单一集合。
+func (p *RAGPass) WithCollections(collections []domain.RAGCollection, allowed []string) *RAGPass {
+	p.collections = collections
+	p.allowed = make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		p.allowed[name] = true
+	}
+	return p
+}
+
+// configured 返回 Profile 中配置的同名集合；未配置集合列表时只有单一的默认集合。
+func (p *RAGPass) configured(name string) (domain.RAGCollection, bool) {
+	if len(p.collections) == 0 {
+		return domain.RAGCollection{Name: p.collectionName}, name == p.collectionName
+	}
+	for _, c := range p.collections {
+		if c.Name == name {
+			return c, true
+		}
 	}
+	return domain.RAGCollection{}, false
 }
 
 func (p *RAGPass) Name() string {
@@ -42,74 +184,67 @@ func (p *RAGPass) Description() string {
 	return "检索增强生成 (RAG)"
 }
 
-func (p *RAGPass) Run(ctx context.Context, data *pipeline.ContextData) error {
-	isEnabled, _ := data.Meta["rag_enabled"].(bool)
-	if !isEnabled {
-		return nil
-	}
+// DefaultPolicy 检索失败时降级为不注入背景知识，不影响主流程。
+func (p *RAGPass) DefaultPolicy() pipeline.Policy {
+	return pipeline.Policy{OnError: pipeline.OnErrorSkip}
+}
 
-	embeddingModel, _ := data.Meta["rag_embedding_model"].(string)
-	if embeddingModel == "" {
-		embeddingModel = p.defaultModelID
-	}
+// DefaultWhen 仅在请求开启 RAG 时执行检索。
+func (p *RAGPass) DefaultWhen() []string {
+	return []string{"rag_enabled"}
+}
 
+func (p *RAGPass) Run(ctx context.Context, data *pipeline.ContextData) error {
 	// 1. 提取 Query
-	var userQuery string
-	for i := len(data.Messages) - 1; i >= 0; i-- {
-		if data.Messages[i].Role == domain.RoleUser {
-			userQuery = data.Messages[i].Content
-			break
-		}
-	}
-
+	userQuery := retrievalQuery(data)
 	if userQuery == "" {
 		return nil
 	}
 
-	log.Printf("[RAGPass] Processing - Query: %s, Model: %s", userQuery, embeddingModel)
-
-	// 2. 获取向量
-	vector, err := p.fetchEmbedding(ctx, userQuery, embeddingModel)
-	if err != nil {
-		log.Printf("[RAGPass] Embedding Error - %v", err)
-		data.Traces = append(data.Traces, map[string]interface{}{
-			"source": "RAGPass",
-			"action": "EmbeddingError",
-			"data":   map[string]interface{}{"error": err.Error()},
-		})
+	routes := p.routes(data)
+	if len(routes) == 0 {
 		return nil
 	}
+	log.Printf("[RAGPass] Processing - Query: %s, Mode: %s, Collections: %d", userQuery, p.retrievalMode(), len(routes))
 
-	// 3. Qdrant 检索
-	results, err := p.searchQdrant(ctx, vector)
+	// 2. 逐个集合检索，合并后重排
+	results, topK, err := p.retrieveAll(ctx, data, userQuery, routes)
 	if err != nil {
-		log.Printf("[RAGPass] Qdrant Search Error - %v", err)
-		data.Traces = append(data.Traces, map[string]interface{}{
-			"source": "RAGPass",
-			"action": "SearchError",
-			"data":   map[string]interface{}{"error": err.Error()},
-		})
-		return nil
+		return err
 	}
+	results = p.rerank.apply(ctx, data, "RAG", userQuery, results, topK)
 
 	log.Printf("[RAGPass] Found %d documents", len(results))
 	if len(results) == 0 {
 		return nil
 	}
 
-	// 4. 注入上下文
+	// 3. 注入上下文
+	// 片段按注入顺序编号，模型在回复中以 [n] 引用，引用列表随消息 Meta 返回并持久化以便前端渲染与事后审计
+	snippets := contentsOf(results)
+	citations, sources := citationsOf(results)
 	var contextBuilder bytes.Buffer
-	for _, content := range results {
-		contextBuilder.WriteString(fmt.Sprintf("---\n%s\n", content))
+	for _, s := range sources {
+		contextBuilder.WriteString(fmt.Sprintf("---\n[%d] %s\n", s.Index, s.Content))
 	}
 	knowledgeContext := contextBuilder.String()
 
 	data.Meta["rag_context"] = knowledgeContext
+	data.Meta[domain.MetaCitations] = citations
+	data.SetAttr("rag_snippets", snippets)
+	data.SetAttr("rag_scores", scoresOf(results))
+	data.SetAttr("rag_citations", citations)
+	data.SetAttr("retrieval_mode", p.retrievalMode())
 
+	content, err := prompts.Inject(localeOf(data), prompts.InjectRAG, prompts.RAGData{Snippets: snippets, Sources: sources})
+	if err != nil {
+		return err
+	}
 	systemMessage := domain.Message{
 		Role:      domain.RoleSystem,
-		Content:   "以下是检索到的参考信息，请结合这些信息回答用户问题：\n\n" + knowledgeContext,
+		Content:   content,
 		Timestamp: time.Now(),
+		Meta:      map[string]interface{}{domain.MetaLayer: domain.LayerRAG, domain.MetaCitations: citations},
 	}
 
 	// 插入到最后一条 User 消息之前
@@ -122,57 +257,173 @@ func (p *RAGPass) Run(ctx context.Context, data *pipeline.ContextData) error {
 		data.Messages = newMsgs
 	}
 
-	data.Traces = append(data.Traces, map[string]interface{}{
-		"source": "RAGPass",
-		"target": "Qdrant",
-		"action": "SearchComplete",
-		"data": map[string]interface{}{
-			"count": len(results),
-		},
-	})
-
 	return nil
 }
 
-func (p *RAGPass) fetchEmbedding(ctx context.Context, text, modelID string) ([]float32, error) {
-	payload := map[string]interface{}{"model": modelID, "input": text}
-	body, _ := json.Marshal(payload)
+// routes 返回本次检索的集合：请求的 rag_collections 优先，其次为 Profile 配置的集合列表，最后为单一的默认集合。
+// 请求只能从已配置的集合与 allowed_collections 中选择，其余集合被忽略并记录事件；请求不能指定向量模型，
+// 集合的向量模型始终取 Profile 配置。请求的 top_k 不能超过该集合配置的 top_k（未配置时为 Pass 的 top_k），
+// 超出时截到上限并记录事件。未设置的 top_k、权重与向量模型取 Pass 的配置。
+func (p *RAGPass) routes(data *pipeline.ContextData) []domain.RAGCollection {
+	routes := p.collections
+	if requested, ok := data.Meta["rag_collections"].([]domain.RAGCollection); ok && len(requested) > 0 {
+		routes = nil
+		for _, c := range requested {
+			if err := validateCollection(c); err != nil {
+				data.Event("RAG", "CollectionRejected", pipeline.Attrs{"collection": c.Name, "error": err.Error()})
+				continue
+			}
+			conf, ok := p.configured(c.Name)
+			if !ok && !p.allowed[c.Name] {
+				data.Event("RAG", "CollectionRejected", pipeline.Attrs{"collection": c.Name, "error": "not in collections or allowed_collections"})
+				continue
+			}
+			// 向量模型必须与集合导入时一致，不接受请求覆盖
+			c.EmbeddingModel = conf.EmbeddingModel
+			limit := conf.TopK
+			if limit == 0 {
+				limit = p.topK
+			}
+			if c.TopK > limit {
+				data.Event("RAG", "CollectionTopKClamped", pipeline.Attrs{"collection": c.Name, "requested": c.TopK, "limit": limit})
+				c.TopK = limit
+			}
+			routes = append(routes, c)
+		}
+		if len(routes) == 0 {
+			return nil
+		}
+	}
+	if len(routes) == 0 {
+		routes = []domain.RAGCollection{{Name: p.collectionName}}
+	}
 
-	req, _ := http.NewRequestWithContext(ctx, "POST", p.embeddingURL, bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
+	out := make([]domain.RAGCollection, len(routes))
+	names := make([]string, len(routes))
+	for i, c := range routes {
+		if c.TopK == 0 {
+			c.TopK = p.topK
+		}
+		if c.Weight == 0 {
+			c.Weight = 1
+		}
+		if c.EmbeddingModel == "" {
+			c.EmbeddingModel = embeddingModelOf(data, p.defaultModelID)
+		}
+		out[i], names[i] = c, c.Name
+	}
+	data.SetAttr("rag_collections", names)
+	return out
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+// retrieveAll 检索全部集合并返回合并后的候选与注入条数上限（各集合 top_k 之和）。
+// 单个集合时直接返回其结果；多个集合时某个集合检索失败只记录事件，全部失败才返回错误。
+// 未开启重排时每个集合先截取各自的 top_k，开启重排时保留全部候选交由重排挑选。
+func (p *RAGPass) retrieveAll(ctx context.Context, data *pipeline.ContextData, query string, routes []domain.RAGCollection) ([]domain.RetrievedChunk, int, error) {
+	if len(routes) == 1 {
+		results, err := p.retrieve(ctx, data, query, routes[0])
+		return results, routes[0].TopK, err
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("embedding gateway returned status %d", resp.StatusCode)
+	var lists [][]domain.RetrievedChunk
+	var weights []float64
+	var errs []error
+	topK := 0
+	for _, route := range routes {
+		hits, err := p.retrieve(ctx, data, query, route)
+		if err != nil {
+			data.Event("RAG", "CollectionFailed", pipeline.Attrs{"collection": route.Name, "error": err.Error()})
+			errs = append(errs, fmt.Errorf("collection %s: %w", route.Name, err))
+			continue
+		}
+		if !p.rerank.cfg.enabled() && len(hits) > route.TopK {
+			hits = hits[:route.TopK]
+		}
+		lists = append(lists, hits)
+		weights = append(weights, route.Weight)
+		topK += route.TopK
+	}
+	if len(lists) == 0 {
+		return nil, 0, errors.Join(errs...)
 	}
+	return mergeCollections(lists, weights, p.rrfK), topK, nil
+}
 
-	var result struct {
-		Data []struct {
-			Embedding []float32 `json:"embedding"`
-		} `json:"data"`
+// retrievalMode 返回实际生效的检索模式：hybrid 模式缺少关键词索引时按 vector 处理，得分保持向量相似度。
+func (p *RAGPass) retrievalMode() string {
+	if p.mode == RetrievalHybrid && p.index == nil {
+		return RetrievalVector
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	return p.mode
+}
+
+// retrieve 按检索模式在单个集合中召回并排序候选；混合检索或开启重排时每路召回 candidates 条，否则为 top_k 条。
+// hybrid 模式下向量检索失败时记录事件并仅使用关键词结果；没有关键词索引可用时返回错误。
+func (p *RAGPass) retrieve(ctx context.Context, data *pipeline.ContextData, query string, route domain.RAGCollection) ([]domain.RetrievedChunk, error) {
+	mode := p.retrievalMode()
+	limit := route.TopK
+	if mode == RetrievalHybrid || p.rerank.cfg.enabled() {
+		limit = p.candidates
+		if limit <= 0 {
+			limit = route.TopK * 4
+		}
+	}
+
+	var vector, lexical []domain.RetrievedChunk
+	if mode != RetrievalLexical {
+		hits, err := p.vectorSearch(ctx, data, query, route, limit)
+		if err != nil {
+			if mode == RetrievalVector || p.index == nil {
+				return nil, err
+			}
+			data.Event("Qdrant", "SearchFailed", pipeline.Attrs{"collection": route.Name, "error": err.Error()})
+		} else {
+			vector = hits
+			data.Event("Qdrant", "SearchComplete", pipeline.Attrs{"collection": route.Name, "count": len(vector)})
+		}
+	}
+	if mode != RetrievalVector && p.index != nil {
+		lexical = p.index.Search(route.Name, query, limit)
+		data.Event("BM25", "SearchComplete", pipeline.Attrs{"collection": route.Name, "count": len(lexical)})
 	}
 
-	if len(result.Data) == 0 {
-		return nil, fmt.Errorf("empty embedding response")
+	results := vector
+	switch mode {
+	case RetrievalLexical:
+		results = lexical
+	case RetrievalHybrid:
+		results = fuseRRF(vector, lexical, p.vectorWeight, p.lexicalWeight, p.rrfK)
+	}
+	for i := range results {
+		results[i].Collection = route.Name
+	}
+	return results, nil
+}
+
+// vectorSearch 计算查询向量并检索集合。
+func (p *RAGPass) vectorSearch(ctx context.Context, data *pipeline.ContextData, query string, route domain.RAGCollection, limit int) ([]domain.RetrievedChunk, error) {
+	// 复用本次运行内的向量缓存，使用相同向量模型的集合只计算一次
+	vector, err := data.Embedding(ctx, query, route.EmbeddingModel)
+	if err != nil {
+		log.Printf("[RAGPass] Embedding Error - %v", err)
+		return nil, fmt.Errorf("embedding failed: %w", err)
 	}
 
-	return result.Data[0].Embedding, nil
+	results, err := p.searchQdrant(ctx, route.Name, vector, limit)
+	if err != nil {
+		log.Printf("[RAGPass] Qdrant Search Error - %v", err)
+		return nil, fmt.Errorf("qdrant search failed: %w", err)
+	}
+	return results, nil
 }
 
-func (p *RAGPass) searchQdrant(ctx context.Context, vector []float32) ([]string, error) {
-	searchURL := fmt.Sprintf("%s/collections/%s/points/search", p.qdrantURL, p.collectionName)
+func (p *RAGPass) searchQdrant(ctx context.Context, collection string, vector []float32, limit int) ([]domain.RetrievedChunk, error) {
+	searchURL := fmt.Sprintf("%s/collections/%s/points/search", p.qdrantURL, url.PathEscape(collection))
 	payload := map[string]interface{}{
 		"vector":       vector,
-		"limit":        p.topK,
+		"limit":        limit,
 		"with_payload": true,
+		"with_vector":  p.rerank.cfg.MMR, // 仅 MMR 需要向量
 	}
 	body, _ := json.Marshal(payload)
 
@@ -192,6 +443,9 @@ func (p *RAGPass) searchQdrant(ctx context.Context, vector []float32) ([]string,
 
 	var searchResponse struct {
 		Result []struct {
+			ID      interface{}            `json:"id"` // UUID 字符串或整数
+			Score   float64                `json:"score"`
+			Vector  json.RawMessage        `json:"vector"` // 命名向量集合时为对象，此时不参与 MMR
 			Payload map[string]interface{} `json:"payload"`
 		} `json:"result"`
 	}
@@ -199,12 +453,28 @@ func (p *RAGPass) searchQdrant(ctx context.Context, vector []float32) ([]string,
 		return nil, err
 	}
 
-	var snippets []string
+	var hits []domain.RetrievedChunk
 	for _, item := range searchResponse.Result {
-		if content, ok := item.Payload["content"].(string); ok {
-			snippets = append(snippets, content)
+		content, ok := item.Payload["content"].(string)
+		if !ok {
+			continue
+		}
+		hit := domain.RetrievedChunk{
+			ID:          fmt.Sprint(item.ID),
+			Content:     content,
+			Score:       item.Score,
+			VectorScore: item.Score,
+			VectorRank:  len(hits) + 1,
+		}
+		_ = json.Unmarshal(item.Vector, &hit.Vector)
+		hit.DocID, _ = item.Payload["doc_id"].(string)
+		hit.Title, _ = item.Payload["title"].(string)
+		hit.Source, _ = item.Payload["source"].(string)
+		if idx, ok := item.Payload["chunk_index"].(float64); ok {
+			hit.ChunkIndex = int(idx)
 		}
+		hits = append(hits, hit)
 	}
 
-	return snippets, nil
+	return hits, nil
 }
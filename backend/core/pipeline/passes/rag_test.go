@@ -0,0 +1,92 @@
+package passes
+
+import (
+	"context-fabric/backend/core/domain"
+	"context-fabric/backend/core/pipeline"
+	"reflect"
+	"testing"
+)
+
+func TestRAGRoutesRequestedCollections(t *testing.T) {
+	profile := []domain.RAGCollection{
+		{Name: "docs", EmbeddingModel: "embed-a"},
+		{Name: "faq", TopK: 2},
+	}
+	tests := []struct {
+		name        string
+		collections []domain.RAGCollection
+		allowed     []string
+		requested   []domain.RAGCollection
+		want        []domain.RAGCollection
+	}{
+		{
+			name:        "profile collections by default",
+			collections: profile,
+			want:        []domain.RAGCollection{{Name: "docs", TopK: 3, Weight: 1, EmbeddingModel: "embed-a"}, {Name: "faq", TopK: 2, Weight: 1, EmbeddingModel: "embed-default"}},
+		},
+		{
+			name:        "request narrows to a configured collection",
+			collections: profile,
+			requested:   []domain.RAGCollection{{Name: "faq", TopK: 1}},
+			want:        []domain.RAGCollection{{Name: "faq", TopK: 1, Weight: 1, EmbeddingModel: "embed-default"}},
+		},
+		{
+			name:        "top_k clamped to the configured collection",
+			collections: profile,
+			requested:   []domain.RAGCollection{{Name: "faq", TopK: 1000000}},
+			want:        []domain.RAGCollection{{Name: "faq", TopK: 2, Weight: 1, EmbeddingModel: "embed-default"}},
+		},
+		{
+			name:        "top_k clamped to the pass default",
+			collections: profile,
+			allowed:     []string{"changelog"},
+			requested:   []domain.RAGCollection{{Name: "docs", TopK: 50}, {Name: "changelog", TopK: 50}},
+			want: []domain.RAGCollection{
+				{Name: "docs", TopK: 3, Weight: 1, EmbeddingModel: "embed-a"},
+				{Name: "changelog", TopK: 3, Weight: 1, EmbeddingModel: "embed-default"},
+			},
+		},
+		{
+			name:        "unconfigured collection denied by default",
+			collections: profile,
+			requested:   []domain.RAGCollection{{Name: "hr-private"}},
+		},
+		{
+			name:      "single configured collection",
+			requested: []domain.RAGCollection{{Name: "other"}, {Name: "kb"}},
+			want:      []domain.RAGCollection{{Name: "kb", TopK: 3, Weight: 1, EmbeddingModel: "embed-default"}},
+		},
+		{
+			name:        "allowed_collections widens the set",
+			collections: profile,
+			allowed:     []string{"changelog"},
+			requested:   []domain.RAGCollection{{Name: "changelog"}, {Name: "hr-private"}},
+			want:        []domain.RAGCollection{{Name: "changelog", TopK: 3, Weight: 1, EmbeddingModel: "embed-default"}},
+		},
+		{
+			name:        "request cannot override the embedding model",
+			collections: profile,
+			requested:   []domain.RAGCollection{{Name: "docs", EmbeddingModel: "embed-b"}},
+			want:        []domain.RAGCollection{{Name: "docs", TopK: 3, Weight: 1, EmbeddingModel: "embed-a"}},
+		},
+		{
+			name:        "invalid name rejected",
+			collections: profile,
+			allowed:     []string{"../docs"},
+			requested:   []domain.RAGCollection{{Name: "../docs"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewRAGPass().WithCollections(tt.collections, tt.allowed)
+			p.collectionName, p.defaultModelID = "kb", "embed-default"
+			data := &pipeline.ContextData{Meta: map[string]interface{}{}}
+			if tt.requested != nil {
+				data.Meta["rag_collections"] = tt.requested
+			}
+			if got := p.routes(data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("routes = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+package passes
+
+import (
+	"context"
+	"context-fabric/backend/core/documents"
+	"context-fabric/backend/core/domain"
+	"context-fabric/backend/core/pipeline"
+	"context-fabric/backend/core/prompts"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 检索模式。
+const (
+	RetrievalVector  = "vector"  // 仅向量检索
+	RetrievalLexical = "lexical" // 仅关键词（BM25）检索
+	RetrievalHybrid  = "hybrid"  // 两者按 RRF 融合
+)
+
+// fuseRRF 以加权倒数排名融合（Reciprocal Rank Fusion）合并向量与关键词检索结果：
+// score = Σ weight / (rrfK + rank)。同一分块（按点 ID）两侧的得分与名次合并到同一条结果中。
+func fuseRRF(vector, lexical []domain.RetrievedChunk, vectorWeight, lexicalWeight float64, rrfK int) []domain.RetrievedChunk {
+	merged := make(map[string]*domain.RetrievedChunk)
+	var order []string
+	add := func(hits []domain.RetrievedChunk, weight float64, lexicalSide bool) {
+		for i, h := range hits {
+			key := h.ID
+			if key == "" {
+				key = h.Content
+			}
+			m, ok := merged[key]
+			if !ok {
+				c := h
+				c.Score = 0
+				m = &c
+				merged[key] = m
+				order = append(order, key)
+			}
+			if lexicalSide {
+				m.LexicalScore, m.LexicalRank = h.LexicalScore, i+1
+			} else {
+				m.VectorScore, m.VectorRank = h.VectorScore, i+1
+			}
+			m.Score += weight / float64(rrfK+i+1)
+		}
+	}
+	add(vector, vectorWeight, false)
+	add(lexical, lexicalWeight, true)
+
+	out := make([]domain.RetrievedChunk, 0, len(order))
+	for _, key := range order {
+		out = append(out, *merged[key])
+	}
+	// 稳定排序：得分相同时保持向量结果在前的原有顺序
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	return out
+}
+
+// mergeCollections 以加权 RRF 合并多个集合各自排好序的结果：score = weight / (rrfK + 集合内名次)。
+// 不同集合的检索得分口径不同，只按名次合并；同一分块（按集合与点 ID）只保留一条。
+func mergeCollections(lists [][]domain.RetrievedChunk, weights []float64, rrfK int) []domain.RetrievedChunk {
+	merged := make(map[string]*domain.RetrievedChunk)
+	var order []string
+	for l, hits := range lists {
+		for i, h := range hits {
+			key := h.Collection + "\x00" + h.ID
+			if h.ID == "" {
+				key = h.Collection + "\x00" + h.Content
+			}
+			m, ok := merged[key]
+			if !ok {
+				c := h
+				c.Score = 0
+				m = &c
+				merged[key] = m
+				order = append(order, key)
+			}
+			m.Score += weights[l] / float64(rrfK+i+1)
+		}
+	}
+
+	out := make([]domain.RetrievedChunk, 0, len(order))
+	for _, key := range order {
+		out = append(out, *merged[key])
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	return out
+}
+
+func validateCollection(c domain.RAGCollection) error {
+	var errs []error
+	if err := documents.ValidateCollection(c.Name); err != nil {
+		errs = append(errs, err)
+	}
+	if c.TopK < 0 {
+		errs = append(errs, fmt.Errorf("top_k must not be negative, got %d", c.TopK))
+	}
+	if c.Weight < 0 {
+		errs = append(errs, fmt.Errorf("weight must not be negative, got %v", c.Weight))
+	}
+	return errors.Join(errs...)
+}
+
+// retrievalScore 是写入 Trace 的单条检索结果得分，不含正文。
+type retrievalScore struct {
+	ID           string  `json:"id"`
+	Collection   string  `json:"collection,omitempty"`
+	DocID        string  `json:"doc_id,omitempty"`
+	ChunkIndex   int     `json:"chunk_index"`
+	Score        float64 `json:"score"`
+	VectorScore  float64 `json:"vector_score,omitempty"`
+	VectorRank   int     `json:"vector_rank,omitempty"`
+	LexicalScore float64 `json:"lexical_score,omitempty"`
+	LexicalRank  int     `json:"lexical_rank,omitempty"`
+	RerankScore  float64 `json:"rerank_score,omitempty"`
+}
+
+func scoresOf(results []domain.RetrievedChunk) []retrievalScore {
+	out := make([]retrievalScore, len(results))
+	for i, r := range results {
+		out[i] = retrievalScore{
+			ID:           r.ID,
+			Collection:   r.Collection,
+			DocID:        r.DocID,
+			ChunkIndex:   r.ChunkIndex,
+			Score:        r.Score,
+			VectorScore:  r.VectorScore,
+			VectorRank:   r.VectorRank,
+			LexicalScore: r.LexicalScore,
+			LexicalRank:  r.LexicalRank,
+			RerankScore:  r.RerankScore,
+		}
+	}
+	return out
+}
+
+func contentsOf(results []domain.RetrievedChunk) []string {
+	out := make([]string, len(results))
+	for i, r := range results {
+		out[i] = r.Content
+	}
+	return out
+}
+
+// citationExcerptRunes 是引用中片段摘录的最大字符数。
+const citationExcerptRunes = 120
+
+// citationsOf 按注入顺序为检索结果编号（从 1 开始），生成不含全文的引用列表与模板使用的参考片段。
+func citationsOf(results []domain.RetrievedChunk) ([]domain.Citation, []prompts.RAGSource) {
+	citations := make([]domain.Citation, len(results))
+	sources := make([]prompts.RAGSource, len(results))
+	for i, r := range results {
+		citations[i] = domain.Citation{
+			Index:      i + 1,
+			Collection: r.Collection,
+			DocID:      r.DocID,
+			Title:      r.Title,
+			Source:     r.Source,
+			ChunkIndex: r.ChunkIndex,
+			Score:      r.Score,
+			Excerpt:    excerpt(r.Content, citationExcerptRunes),
+		}
+		sources[i] = prompts.RAGSource{Index: i + 1, Title: r.Title, Content: r.Content}
+	}
+	return citations, sources
+}
+
+// excerpt 压缩空白后截取文本开头至多 n 个字符，截断时以省略号结尾。
+func excerpt(text string, n int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return string(runes[:n]) + "…"
+}
+
+// RerankConfig 是检索结果注入前的重排参数，RAGPass 与 Constitution 共用。
+// 处理顺序：交叉编码器重排 -> 最低得分过滤 -> MMR 多样性挑选 -> 截取前 top_k。
+type RerankConfig struct {
+	Model     string  `json:"model"`      // 交叉编码器模型，经 LLM 网关的 /v1/rerank 打分；留空表示不重排
+	MinScore  float64 `json:"min_score"`  // 最低得分，重排后比较重排得分，否则比较向量余弦相似度；0 表示不过滤
+	MMR       bool    `json:"mmr"`        // 按最大边际相关性挑选，减少近似重复的片段
+	MMRLambda float64 `json:"mmr_lambda"` // MMR 中相关性的权重，取值 (0, 1]，越小越偏向多样性
+}
+
+// defaultRerank 默认不做任何重排，开启 MMR 时相关性权重为 0.7。
+var defaultRerank = RerankConfig{MMRLambda: 0.7}
+
+func (c RerankConfig) Validate() error {
+	if c.MMRLambda <= 0 || c.MMRLambda > 1 {
+		return fmt.Errorf("mmr_lambda must be in (0, 1], got %v", c.MMRLambda)
+	}
+	return nil
+}
+
+// enabled 表示是否配置了任一重排步骤，开启时检索会多召回候选供挑选。
+func (c RerankConfig) enabled() bool {
+	return c.Model != "" || c.MinScore != 0 || c.MMR
+}
+
+// reranker 执行 RerankConfig 描述的重排步骤。
+type reranker struct {
+	cfg    RerankConfig
+	client pipeline.Reranker
+}
+
+// apply 对候选结果依次重排、过滤并做多样性挑选，返回至多 topK 条；target 为 Trace 事件的目标名。
+// 交叉编码器不可用或调用失败时保留原有排序，并跳过按重排得分设定的阈值过滤。
+func (r reranker) apply(ctx context.Context, data *pipeline.ContextData, target, query string, hits []domain.RetrievedChunk, topK int) []domain.RetrievedChunk {
+	filter, reranked := r.cfg.MinScore != 0, false
+	if r.cfg.Model != "" && len(hits) > 0 {
+		scores, err := r.crossEncode(ctx, query, hits)
+		if err != nil {
+			data.Event(target, "RerankFailed", pipeline.Attrs{"model": r.cfg.Model, "error": err.Error()})
+			filter = false
+		} else {
+			reranked = true
+			for i := range hits {
+				hits[i].RerankScore, hits[i].Score = scores[i], scores[i]
+			}
+			sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+			data.Event(target, "Reranked", pipeline.Attrs{"model": r.cfg.Model, "count": len(hits)})
+		}
+	}
+
+	if filter {
+		kept := make([]domain.RetrievedChunk, 0, len(hits))
+		for _, h := range hits {
+			// 融合后的 Score 是 RRF 名次得分，阈值只与重排得分或向量相似度比较
+			score := h.VectorScore
+			if reranked {
+				score = h.RerankScore
+			}
+			if score >= r.cfg.MinScore {
+				kept = append(kept, h)
+			}
+		}
+		if dropped := len(hits) - len(kept); dropped > 0 {
+			data.Event(target, "BelowMinScore", pipeline.Attrs{"min_score": r.cfg.MinScore, "dropped": dropped})
+		}
+		hits = kept
+	}
+
+	if r.cfg.MMR && len(hits) > topK {
+		hits = mmr(hits, topK, r.cfg.MMRLambda)
+		data.Event(target, "MMR", pipeline.Attrs{"lambda": r.cfg.MMRLambda, "selected": len(hits)})
+	}
+	if len(hits) > topK {
+		hits = hits[:topK]
+	}
+	return hits
+}
+
+func (r reranker) crossEncode(ctx context.Context, query string, hits []domain.RetrievedChunk) ([]float64, error) {
+	if r.client == nil {
+		return nil, fmt.Errorf("no reranker configured")
+	}
+	scores, err := r.client.Rerank(ctx, r.cfg.Model, query, contentsOf(hits))
+	if err == nil && len(scores) != len(hits) {
+		err = fmt.Errorf("expected %d scores, got %d", len(hits), len(scores))
+	}
+	return scores, err
+}
+
+// rerankItems 将任意检索条目转换为 RetrievedChunk 执行重排，按保留下来的顺序返回原条目。
+func rerankItems[T any](ctx context.Context, data *pipeline.ContextData, r reranker, target, query string, items []T, topK int, toChunk func(T) domain.RetrievedChunk) []T {
+	hits := make([]domain.RetrievedChunk, len(items))
+	for i, item := range items {
+		hits[i] = toChunk(item)
+		hits[i].ID = strconv.Itoa(i)
+	}
+	kept := r.apply(ctx, data, target, query, hits, topK)
+	out := make([]T, len(kept))
+	for j, h := range kept {
+		i, _ := strconv.Atoi(h.ID)
+		out[j] = items[i]
+	}
+	return out
+}
+
+// mmr 按最大边际相关性从候选中挑选 k 条：每次选出 λ·相关性 − (1−λ)·与已选结果的最大相似度 最高者。
+// 相关性为按候选得分区间归一化后的得分；全部候选都带同维向量时相似度用向量余弦，否则一律用词项 Jaccard 相似度，
+// 同一次挑选只使用一种口径，避免两种尺度的相似度互相比较。
+func mmr(hits []domain.RetrievedChunk, k int, lambda float64) []domain.RetrievedChunk {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, h := range hits {
+		lo, hi = math.Min(lo, h.Score), math.Max(hi, h.Score)
+	}
+	rel := make([]float64, len(hits))
+	for i, h := range hits {
+		rel[i] = 1
+		if hi > lo {
+			rel[i] = (h.Score - lo) / (hi - lo)
+		}
+	}
+
+	useVectors := true
+	for _, h := range hits {
+		if len(h.Vector) == 0 || len(h.Vector) != len(hits[0].Vector) {
+			useVectors = false
+			break
+		}
+	}
+	var similarity func(i, j int) float64
+	if useVectors {
+		similarity = func(i, j int) float64 { return cosine(hits[i].Vector, hits[j].Vector) }
+	} else {
+		terms := make([]map[string]bool, len(hits))
+		for i, h := range hits {
+			terms[i] = make(map[string]bool)
+			for _, t := range documents.Terms(h.Content) {
+				terms[i][t] = true
+			}
+		}
+		similarity = func(i, j int) float64 { return jaccard(terms[i], terms[j]) }
+	}
+
+	selected := make([]domain.RetrievedChunk, 0, k)
+	used := make([]bool, len(hits))
+	maxSim := make([]float64, len(hits))
+	for len(selected) < k {
+		best, bestVal := -1, math.Inf(-1)
+		for i := range hits {
+			if used[i] {
+				continue
+			}
+			if v := lambda*rel[i] - (1-lambda)*maxSim[i]; v > bestVal {
+				best, bestVal = i, v
+			}
+		}
+		if best < 0 {
+			break
+		}
+		used[best] = true
+		selected = append(selected, hits[best])
+		for i := range hits {
+			if !used[i] {
+				maxSim[i] = math.Max(maxSim[i], similarity(i, best))
+			}
+		}
+	}
+	return selected
+}
+
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	inter := 0
+	for t := range a {
+		if b[t] {
+			inter++
+		}
+	}
+	return float64(inter) / float64(len(a)+len(b)-inter)
+}
@@ -0,0 +1,189 @@
+package passes
+
+import (
+	"context-fabric/backend/core/domain"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func hits(ids ...string) []domain.RetrievedChunk {
+	out := make([]domain.RetrievedChunk, len(ids))
+	for i, id := range ids {
+		out[i] = domain.RetrievedChunk{ID: id, Content: "content " + id, VectorScore: 0.9, LexicalScore: 3}
+	}
+	return out
+}
+
+func idsOf(results []domain.RetrievedChunk) []string {
+	out := make([]string, len(results))
+	for i, r := range results {
+		out[i] = r.ID
+	}
+	return out
+}
+
+func TestFuseRRF(t *testing.T) {
+	tests := []struct {
+		name           string
+		vector         []domain.RetrievedChunk
+		lexical        []domain.RetrievedChunk
+		vw, lw         float64
+		want           []string
+		wantTopScore   float64
+		wantTopVecRank int
+		wantTopLexRank int
+	}{
+		{
+			name:   "disjoint lists interleave by rank",
+			vector: hits("v1", "v2"), lexical: hits("l1", "l2"),
+			vw: 1, lw: 1,
+			want:         []string{"v1", "l1", "v2", "l2"},
+			wantTopScore: 1.0 / 61, wantTopVecRank: 1,
+		},
+		{
+			name:   "overlap sums both sides",
+			vector: hits("a", "b", "c"), lexical: hits("c", "d"),
+			vw: 1, lw: 1,
+			// b 与 d 得分相同，保持向量结果在前
+			want:         []string{"c", "a", "b", "d"},
+			wantTopScore: 1.0/63 + 1.0/61, wantTopVecRank: 3, wantTopLexRank: 1,
+		},
+		{
+			name:   "lexical weight dominates",
+			vector: hits("v1", "v2"), lexical: hits("l1", "l2"),
+			vw: 1, lw: 3,
+			want:         []string{"l1", "l2", "v1", "v2"},
+			wantTopScore: 3.0 / 61, wantTopLexRank: 1,
+		},
+		{
+			name:   "zero weight keeps order of the other side",
+			vector: hits("v1", "v2"), lexical: hits("l1"),
+			vw: 0, lw: 1,
+			want:         []string{"l1", "v1", "v2"},
+			wantTopScore: 1.0 / 61, wantTopLexRank: 1,
+		},
+		{
+			name:    "one side empty",
+			lexical: hits("l1", "l2"),
+			vw:      1, lw: 1,
+			want:         []string{"l1", "l2"},
+			wantTopScore: 1.0 / 61, wantTopLexRank: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fuseRRF(tt.vector, tt.lexical, tt.vw, tt.lw, 60)
+			if ids := idsOf(got); !reflect.DeepEqual(ids, tt.want) {
+				t.Fatalf("order = %v, want %v", ids, tt.want)
+			}
+			top := got[0]
+			if math.Abs(top.Score-tt.wantTopScore) > 1e-12 {
+				t.Errorf("top score = %v, want %v", top.Score, tt.wantTopScore)
+			}
+			if top.VectorRank != tt.wantTopVecRank || top.LexicalRank != tt.wantTopLexRank {
+				t.Errorf("top ranks = vector %d lexical %d, want %d %d", top.VectorRank, top.LexicalRank, tt.wantTopVecRank, tt.wantTopLexRank)
+			}
+		})
+	}
+}
+
+func inCollection(name string, ids ...string) []domain.RetrievedChunk {
+	out := hits(ids...)
+	for i := range out {
+		out[i].Collection = name
+		out[i].Score = float64(100 - i) // 集合内得分口径不同，合并时不应参与比较
+	}
+	return out
+}
+
+func TestMergeCollections(t *testing.T) {
+	tests := []struct {
+		name    string
+		lists   [][]domain.RetrievedChunk
+		weights []float64
+		want    []string
+	}{
+		{
+			name:    "equal weights interleave",
+			lists:   [][]domain.RetrievedChunk{inCollection("a", "a1", "a2"), inCollection("b", "b1", "b2")},
+			weights: []float64{1, 1},
+			want:    []string{"a1", "b1", "a2", "b2"},
+		},
+		{
+			name:    "heavier collection first",
+			lists:   [][]domain.RetrievedChunk{inCollection("a", "a1", "a2"), inCollection("b", "b1", "b2")},
+			weights: []float64{1, 2},
+			want:    []string{"b1", "b2", "a1", "a2"},
+		},
+		{
+			name:    "same point id in different collections kept apart",
+			lists:   [][]domain.RetrievedChunk{inCollection("a", "x"), inCollection("b", "x")},
+			weights: []float64{1, 1},
+			want:    []string{"x", "x"},
+		},
+		{
+			name:    "duplicate within a collection merged",
+			lists:   [][]domain.RetrievedChunk{inCollection("a", "x", "y"), inCollection("a", "y")},
+			weights: []float64{1, 1},
+			want:    []string{"y", "x"},
+		},
+		{
+			name:    "empty list",
+			lists:   [][]domain.RetrievedChunk{nil, inCollection("b", "b1")},
+			weights: []float64{1, 1},
+			want:    []string{"b1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeCollections(tt.lists, tt.weights, 60)
+			if ids := idsOf(got); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("order = %v, want %v", ids, tt.want)
+			}
+			for _, r := range got {
+				if r.Score >= 1 {
+					t.Errorf("%s/%s score %v is not an RRF score", r.Collection, r.ID, r.Score)
+				}
+			}
+		})
+	}
+}
+
+func TestMMRUsesOneSimilarity(t *testing.T) {
+	// c 与 a 文本相同，但只有 a 带向量：缺少任一向量时整次挑选都按 Jaccard 计算，c 被视为重复
+	candidates := []domain.RetrievedChunk{
+		{ID: "a", Content: "reset the gateway", Score: 1, Vector: []float32{1, 0}},
+		{ID: "b", Content: "billing limits", Score: 0.8, Vector: []float32{1, 0}},
+		{ID: "c", Content: "reset the gateway", Score: 0.9},
+	}
+	if got := idsOf(mmr(candidates, 2, 0.5)); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("mmr = %v, want [a b]", got)
+	}
+
+	// 全部带向量时按余弦计算：b 与 a 方向相同被视为重复
+	candidates[2].Vector = []float32{0, 1}
+	if got := idsOf(mmr(candidates, 2, 0.5)); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("mmr = %v, want [a c]", got)
+	}
+}
+
+func TestRAGConfigMinScore(t *testing.T) {
+	tests := []struct {
+		mode    string
+		model   string
+		wantErr bool
+	}{
+		{RetrievalVector, "", false},
+		{RetrievalHybrid, "", true},
+		{RetrievalLexical, "", true},
+		{RetrievalHybrid, "bge-reranker", false},
+	}
+	for _, tt := range tests {
+		cfg := RAGConfig{TopK: 3, Mode: tt.mode, VectorWeight: 1, LexicalWeight: 1, RRFK: 60,
+			Rerank: RerankConfig{Model: tt.model, MinScore: 0.5, MMRLambda: 0.7}}
+		if err := cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("mode %s model %q: Validate() = %v, wantErr %v", tt.mode, tt.model, err, tt.wantErr)
+		}
+	}
+}
@@ -7,6 +7,13 @@ import (
 	"log"
 )
 
+func init() {
+	pipeline.RegisterPass("Sanitizer", "提取对话事实并存入暂存区", struct{}{},
+		func(_ struct{}, deps pipeline.Dependencies) (pipeline.Pass, error) {
+			return NewSanitizePass(deps.Memory), nil
+		})
+}
+
 // SanitizePass 负责在会话处理完成后触发记忆清洗与录入。
 // 这是一个“观察者”类型的 Pass，它不修改上下文内容，只负责异步提取事实。
 type SanitizePass struct {
@@ -1,22 +1,82 @@
 package passes
 
 import (
-	"bytes"
 	"context"
 	"context-fabric/backend/core/domain"
 	"context-fabric/backend/core/pipeline"
-	"encoding/json"
+	"context-fabric/backend/core/prompts"
+	"context-fabric/backend/core/tokens"
+	"errors"
 	"fmt"
-	"net/http"
+	"log"
+	"strings"
 	"time"
 )
 
+// SummarizerConfig 是 SummarizerPass 的可配置参数。
+type SummarizerConfig struct {
+	Model            string `json:"model"`              // 摘要模型，请求的 summary_model_id 可覆盖；留空时使用请求的 model_id
+	MaxHistory       int    `json:"max_history"`        // 历史消息数超过该值时触发，0 表示不按条数触发
+	MaxHistoryTokens int    `json:"max_history_tokens"` // 历史 Token 数超过该值时触发，0 表示不按 Token 触发
+	KeepRecent       int    `json:"keep_recent"`        // 保留原文的最近消息数上限
+	KeepRecentTokens int    `json:"keep_recent_tokens"` // 保留原文的最近消息 Token 上限，0 表示不限
+	ChunkSize        int    `json:"chunk_size"`         // 分层模式：每段摘要覆盖的消息数，0 表示单层滚动摘要
+	MaxChunks        int    `json:"max_chunks"`         // 分层模式：分段摘要超过该数量时，最早的分段汇总进会话级摘要
+}
+
+func (c *SummarizerConfig) Validate() error {
+	var errs []error
+	if c.MaxHistory <= 0 && c.MaxHistoryTokens <= 0 {
+		errs = append(errs, errors.New("at least one of max_history and max_history_tokens must be positive"))
+	}
+	if c.KeepRecent <= 0 {
+		errs = append(errs, fmt.Errorf("keep_recent must be positive, got %d", c.KeepRecent))
+	}
+	if c.MaxHistory > 0 && c.MaxHistory <= c.KeepRecent {
+		errs = append(errs, fmt.Errorf("max_history (%d) must be greater than keep_recent (%d)", c.MaxHistory, c.KeepRecent))
+	}
+	if c.MaxHistoryTokens < 0 || c.KeepRecentTokens < 0 {
+		errs = append(errs, fmt.Errorf("max_history_tokens and keep_recent_tokens must not be negative, got %d and %d", c.MaxHistoryTokens, c.KeepRecentTokens))
+	}
+	if c.MaxHistoryTokens > 0 && c.KeepRecentTokens >= c.MaxHistoryTokens {
+		errs = append(errs, fmt.Errorf("keep_recent_tokens (%d) must be less than max_history_tokens (%d)", c.KeepRecentTokens, c.MaxHistoryTokens))
+	}
+	if c.ChunkSize < 0 {
+		errs = append(errs, fmt.Errorf("chunk_size must not be negative, got %d", c.ChunkSize))
+	}
+	if c.ChunkSize > 0 && c.MaxChunks <= 0 {
+		errs = append(errs, fmt.Errorf("max_chunks must be positive in hierarchical mode, got %d", c.MaxChunks))
+	}
+	return errors.Join(errs...)
+}
+
+func init() {
+	// 默认在消息数超过 10 条时触发摘要，保留最近 5 条；摘要模型跟随请求
+	pipeline.RegisterPass("Summarizer", "LLM 语义摘要压缩",
+		SummarizerConfig{MaxHistory: 10, KeepRecent: 5, MaxChunks: 4},
+		func(cfg SummarizerConfig, deps pipeline.Dependencies) (pipeline.Pass, error) {
+			if deps.LLMServiceURL == "" {
+				return nil, errors.New("llm service url is not configured")
+			}
+			return NewSummarizerPass(deps.LLMServiceURL, cfg.Model, cfg.MaxHistory, cfg.KeepRecent).
+				WithTokens(cfg.MaxHistoryTokens, cfg.KeepRecentTokens).
+				WithChunks(cfg.ChunkSize, cfg.MaxChunks).
+				WithStore(deps.History), nil
+		})
+}
+
 // SummarizerPass 使用 LLM 对历史消息进行语义摘要，以压缩上下文并保留长期记忆。
 type SummarizerPass struct {
-	LLMServiceURL string // LLM 网关的基础地址
-	ModelID       string // 用于执行摘要的模型 ID
-	MaxHistory    int    // 触发摘要的消息数阈值
-	KeepRecent    int    // 摘要后保留的最近消息数（不参与摘要）
+	LLMServiceURL    string // LLM 网关的基础地址
+	ModelID          string // 执行摘要的模型 ID，请求的 summary_model_id 可覆盖，留空时使用请求的 model_id
+	MaxHistory       int    // 触发摘要的消息数阈值，0 表示不按条数触发
+	MaxHistoryTokens int    // 触发摘要的 Token 阈值，0 表示不按 Token 触发
+	KeepRecent       int    // 摘要后保留的最近消息数（不参与摘要）
+	KeepRecentTokens int    // 摘要后保留的最近消息 Token 上限，0 表示不限
+	ChunkSize        int    // 分层模式下每段摘要覆盖的消息数，0 表示单层滚动摘要
+	MaxChunks        int    // 分层模式下保留的分段摘要数
+
+	store pipeline.HistoryStore // 滚动摘要的持久化存储，为空时每次请求都重新生成
 }
 
 // NewSummarizerPass 创建一个新的摘要处理器。
@@ -29,6 +89,26 @@ func NewSummarizerPass(url, model string, maxHistory, keepRecent int) *Summarize
 	}
 }
 
+// WithTokens 开启按 Token 触发：历史超过 maxTokens 时摘要，并让保留的最近消息不超过 keepTokens。
+func (p *SummarizerPass) WithTokens(maxTokens, keepTokens int) *SummarizerPass {
+	p.MaxHistoryTokens = maxTokens
+	p.KeepRecentTokens = keepTokens
+	return p
+}
+
+// WithChunks 开启分层摘要：每 size 条消息生成一段摘要，超过 maxChunks 段时最早的分段汇总进会话级摘要。
+func (p *SummarizerPass) WithChunks(size, maxChunks int) *SummarizerPass {
+	p.ChunkSize = size
+	p.MaxChunks = maxChunks
+	return p
+}
+
+// WithStore 设置滚动摘要的持久化存储。
+func (p *SummarizerPass) WithStore(store pipeline.HistoryStore) *SummarizerPass {
+	p.store = store
+	return p
+}
+
 func (p *SummarizerPass) Name() string {
 	return "Summarizer"
 }
@@ -37,117 +117,263 @@ func (p *SummarizerPass) Description() string {
 	return "LLM 语义摘要压缩"
 }
 
-// Run 执行摘要逻辑：将较旧的消息打包发送给 LLM 进行总结，并替换为一条摘要消息。
+// DefaultPolicy 摘要失败时保留原始历史，交由后续截断兜底。
+func (p *SummarizerPass) DefaultPolicy() pipeline.Policy {
+	return pipeline.Policy{OnError: pipeline.OnErrorSkip}
+}
+
+// Run 执行摘要逻辑：对话历史的条数或 Token 数超过阈值时，将滑出保留窗口的旧消息折叠为一条摘要消息。
+// 会话中已持久化的滚动摘要覆盖前 N 条历史时，只将新滑出窗口的消息交给 LLM 更新；
+// 没有新消息滑出时直接复用已有摘要。注入类消息（RAG、记忆等）不参与摘要，保持原位。
 func (p *SummarizerPass) Run(ctx context.Context, data *pipeline.ContextData) error {
-	// 如果消息总数未达到触发阈值，直接跳过
-	if len(data.Messages) <= p.MaxHistory {
+	history := historyIndices(data.Messages)
+	modelID, _ := data.Meta["model_id"].(string)
+	split, trigger := p.window(data.Messages, history, tokens.ForModel(modelID))
+	if trigger == "" || split <= 0 {
 		return nil
 	}
 
-	start := time.Now()
-	// 划分消息：前部用于摘要，后部保留原始状态
-	splitIdx := len(data.Messages) - p.KeepRecent
-	if splitIdx <= 0 {
-		return nil
+	summary := p.loadSummary(ctx, data)
+	// 摘要覆盖范围超出当前窗口（如调整了 keep_recent 或历史被删减）时重新生成
+	if summary != nil && summary.Covered > split {
+		summary = nil
 	}
 
-	toSummarize := data.Messages[:splitIdx]
-	recentMessages := data.Messages[splitIdx:]
+	// 分层模式只摘要完整的分段，不足一段的消息暂时保留原文
+	covered := split
+	if p.ChunkSize > 0 {
+		from := 0
+		if summary != nil {
+			from = summary.Covered
+		}
+		covered = from + (split-from)/p.ChunkSize*p.ChunkSize
+	}
 
-	// 序列化待摘要的内容
-	historyText := ""
-	for _, m := range toSummarize {
-		historyText += fmt.Sprintf("%s: %s\n", m.Role, m.Content)
+	if covered == 0 {
+		return nil
 	}
 
-	// 调用远程 LLM 服务生成摘要
-	summary, err := p.requestSummary(ctx, historyText)
-	if err != nil {
-		// 容错处理：记录错误 Trace 但不中断管线执行
-		data.Traces = append(data.Traces, map[string]interface{}{
-			"source": "Summarizer",
-			"target": "LLMService",
-			"action": "SummarizeError",
-			"data": map[string]interface{}{
-				"error":     err.Error(),
-				"model_id":  p.ModelID,
-				"msg_count": len(toSummarize),
-			},
+	if summary != nil && summary.Covered == covered {
+		data.Event("HistoryStore", "SummaryReused", pipeline.Attrs{
+			"covered": covered,
 		})
-		return nil
+	} else {
+		model := summaryModelOf(data, p.ModelID)
+		if model == "" {
+			return errors.New("no summarization model: set summary_model_id in the request, model in the pass params, or model_id in the request")
+		}
+		next, err := p.rollSummary(ctx, data, model, summary, history, covered)
+		if err != nil {
+			return err
+		}
+		summary = next
+		if p.store != nil && !data.DryRun {
+			// 持久化失败不影响本次使用，下次请求会重新生成
+			if err := p.store.SaveSummary(ctx, data.SessionID, summary); err != nil {
+				log.Printf("[Summarizer] Save summary failed - Session: %s, Error: %v", data.SessionID, err)
+				data.Event("HistoryStore", "SaveSummaryFailed", pipeline.Attrs{"error": err.Error()})
+			}
+		}
 	}
+	data.SetAttr("trigger", trigger)
 
-	// 构造摘要消息，作为历史背景注入
+	// 按请求语言构造摘要消息，作为历史背景注入
+	body, err := renderSummary(localeOf(data), summary)
+	if err != nil {
+		return err
+	}
+	content, err := prompts.Inject(localeOf(data), prompts.InjectSummary, prompts.SummaryData{Body: body})
+	if err != nil {
+		return err
+	}
 	summaryMsg := domain.Message{
 		Role:      domain.RoleSystem,
-		Content:   fmt.Sprintf("[历史会话摘要]:\n%s", summary),
+		Content:   content,
 		Timestamp: time.Now(),
-		Meta:      map[string]interface{}{"is_summary": true},
+		Meta:      map[string]interface{}{"is_summary": true, "summary_covered": summary.Covered, domain.MetaLayer: domain.LayerSummary, domain.MetaPinned: true},
 	}
 
-	// 重组消息列表：摘要消息 + 最近的原始消息
-	data.Messages = append([]domain.Message{summaryMsg}, recentMessages...)
+	// 重组消息列表：摘要消息 + 未被摘要覆盖的消息
+	drop := make(map[int]bool, summary.Covered)
+	for _, i := range history[:summary.Covered] {
+		drop[i] = true
+	}
+	msgs := make([]domain.Message, 0, len(data.Messages)-summary.Covered+1)
+	msgs = append(msgs, summaryMsg)
+	for i, m := range data.Messages {
+		if !drop[i] {
+			msgs = append(msgs, m)
+		}
+	}
+	data.Messages = msgs
+	return nil
+}
 
-	duration := time.Since(start).Milliseconds()
-	data.Traces = append(data.Traces, map[string]interface{}{
-		"source": "Summarizer",
-		"target": "LLMService",
-		"action": "Summarized",
-		"data": map[string]interface{}{
-			"original_count": len(toSummarize),
-			"duration_ms":    duration,
-			"summary_length": len(summary),
-		},
-	})
+// window 判断是否触发摘要并计算保留窗口的起点：从最新的历史消息向前保留，
+// 条数不超过 KeepRecent，且设置了 KeepRecentTokens 时 Token 不超过该值（至少保留一条）。
+// 返回的 trigger 为触发原因（messages / tokens），未触发时为空。
+func (p *SummarizerPass) window(msgs []domain.Message, history []int, counter *tokens.Counter) (split int, trigger string) {
+	counts := make([]int, len(history))
+	total := 0
+	for k, i := range history {
+		counts[k] = counter.CountMessage(msgs[i])
+		total += counts[k]
+	}
+	switch {
+	case p.MaxHistory > 0 && len(history) > p.MaxHistory:
+		trigger = "messages"
+	case p.MaxHistoryTokens > 0 && total > p.MaxHistoryTokens:
+		trigger = "tokens"
+	default:
+		return 0, ""
+	}
 
-	return nil
+	kept, keptTokens := 0, 0
+	for k := len(history) - 1; k >= 0 && kept < p.KeepRecent; k-- {
+		if kept > 0 && p.KeepRecentTokens > 0 && keptTokens+counts[k] > p.KeepRecentTokens {
+			break
+		}
+		kept++
+		keptTokens += counts[k]
+	}
+	return len(history) - kept, trigger
 }
 
-// requestSummary 向 LLM 网关发起同步的摘要请求
-func (p *SummarizerPass) requestSummary(ctx context.Context, text string) (string, error) {
-	prompt := fmt.Sprintf("请简要总结以下对话历史，提取核心事实、用户偏好和重要决策。要求：简洁、客观，不超过 200 字。\n\n对话历史：\n%s", text)
+// summaryModelOf 返回执行摘要的模型：请求指定的 summary_model_id 优先，其次为 Pass 配置的模型，最后为请求的 model_id。
+func summaryModelOf(data *pipeline.ContextData, configured string) string {
+	if m, _ := data.Meta["summary_model_id"].(string); m != "" {
+		return m
+	}
+	if configured != "" {
+		return configured
+	}
+	m, _ := data.Meta["model_id"].(string)
+	return m
+}
 
-	payload := map[string]interface{}{
-		"model": p.ModelID,
-		"messages": []map[string]string{
-			{"role": "user", "content": prompt},
-		},
-		"stream": false,
+// historyIndices 返回对话历史消息的下标。HistoryLoader 按会话顺序加载，
+// 因此第 k 个历史消息即会话中的第 k 条消息，与 RollingSummary.Covered 的计数口径一致。
+func historyIndices(msgs []domain.Message) []int {
+	var idx []int
+	for i, m := range msgs {
+		if domain.LayerOf(m) == domain.LayerHistory {
+			idx = append(idx, i)
+		}
 	}
+	return idx
+}
 
-	body, _ := json.Marshal(payload)
-	// 构建请求，指回 llm-service 的标准 completions 接口 (带 /v1 前缀)
-	req, err := http.NewRequestWithContext(ctx, "POST", p.LLMServiceURL+"/v1/chat/completions", bytes.NewBuffer(body))
+// loadSummary 读取会话已持久化的滚动摘要，未配置存储或读取失败时返回 nil。
+func (p *SummarizerPass) loadSummary(ctx context.Context, data *pipeline.ContextData) *domain.RollingSummary {
+	if p.store == nil {
+		return nil
+	}
+	sess, err := p.store.Get(ctx, data.SessionID)
 	if err != nil {
-		return "", err
+		return nil
 	}
-	req.Header.Set("Content-Type", "application/json")
+	return sess.Summary
+}
 
-	// 设置较长的超时时间给摘要任务
-	client := &http.Client{Timeout: 45 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+// renderSummary 按语言将会话级摘要与分段摘要拼接为摘要正文。
+func renderSummary(locale string, s *domain.RollingSummary) (string, error) {
+	in := prompts.SummaryBodyData{Content: s.Content}
+	for _, c := range s.Chunks {
+		in.Chunks = append(in.Chunks, prompts.SummaryChunkData{From: c.From + 1, To: c.To, Content: c.Content})
 	}
-	defer resp.Body.Close()
+	return prompts.Inject(locale, prompts.InjectSummaryBody, in)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("LLM 服务返回错误状态码: %d", resp.StatusCode)
+// serializeHistory 将历史消息序列化为摘要输入。
+func serializeHistory(msgs []domain.Message, idx []int) string {
+	var sb strings.Builder
+	for _, i := range idx {
+		sb.WriteString(fmt.Sprintf("%s: %s\n", msgs[i].Role, msgs[i].Content))
 	}
+	return sb.String()
+}
 
-	var res struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+// rollSummary 将 prev 之后、covered 之前的历史消息合并进摘要；prev 为 nil 时从头生成。
+// 单层模式将新消息连同上一版摘要交给 LLM 更新；分层模式为每个完整分段单独生成摘要，
+// 分段数超过 MaxChunks 时再将最早的分段汇总进会话级摘要。
+func (p *SummarizerPass) rollSummary(ctx context.Context, data *pipeline.ContextData, model string, prev *domain.RollingSummary, history []int, covered int) (*domain.RollingSummary, error) {
+	start := time.Now()
+	locale := localeOf(data)
+	next := &domain.RollingSummary{Model: model}
+	if prev != nil {
+		next.Content, next.Covered = prev.Content, prev.Covered
+		next.Chunks = append(next.Chunks, prev.Chunks...)
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return "", err
+	from := next.Covered
+	attrs := pipeline.Attrs{"original_count": covered - from, "incremental": prev != nil, "model": model}
+
+	if p.ChunkSize <= 0 {
+		// 单层：切换自分层模式时，将已有分段一并作为上一版摘要
+		in := prompts.SummarizeData{History: serializeHistory(data.Messages, history[from:covered])}
+		name := prompts.InjectSummarize
+		if prev != nil {
+			previous, err := renderSummary(locale, prev)
+			if err != nil {
+				return nil, err
+			}
+			in.Previous, name = previous, prompts.InjectSummarizeIncremental
+		}
+		prompt, err := prompts.Inject(locale, name, in)
+		if err != nil {
+			return nil, err
+		}
+		content, err := p.requestSummary(ctx, model, prompt)
+		if err != nil {
+			return nil, fmt.Errorf("summarize %d messages with %s: %w", covered-from, model, err)
+		}
+		next.Content, next.Chunks = content, nil
+	} else {
+		for lo := from; lo+p.ChunkSize <= covered; lo += p.ChunkSize {
+			hi := lo + p.ChunkSize
+			prompt, err := prompts.Inject(locale, prompts.InjectSummarize, prompts.SummarizeData{History: serializeHistory(data.Messages, history[lo:hi])})
+			if err != nil {
+				return nil, err
+			}
+			content, err := p.requestSummary(ctx, model, prompt)
+			if err != nil {
+				return nil, fmt.Errorf("summarize messages %d-%d with %s: %w", lo, hi, model, err)
+			}
+			next.Chunks = append(next.Chunks, domain.SummaryChunk{Content: content, From: lo, To: hi})
+		}
+		attrs["chunks"] = len(next.Chunks)
+		if n := len(next.Chunks) - p.MaxChunks; n > 0 {
+			var sb strings.Builder
+			for _, c := range next.Chunks[:n] {
+				sb.WriteString(fmt.Sprintf("- %s\n", c.Content))
+			}
+			prompt, err := prompts.Inject(locale, prompts.InjectSummarizeRollup, prompts.RollupData{Summary: next.Content, Chunks: sb.String()})
+			if err != nil {
+				return nil, err
+			}
+			content, err := p.requestSummary(ctx, model, prompt)
+			if err != nil {
+				return nil, fmt.Errorf("roll up %d chunk summaries with %s: %w", n, model, err)
+			}
+			next.Content = content
+			next.Chunks = append([]domain.SummaryChunk(nil), next.Chunks[n:]...)
+			attrs["rolled_up"] = n
+		}
 	}
+	next.Covered = covered
+	next.UpdatedAt = time.Now()
 
-	if len(res.Choices) > 0 {
-		return res.Choices[0].Message.Content, nil
+	attrs["covered"] = covered
+	attrs["duration_ms"] = time.Since(start).Milliseconds()
+	body, err := renderSummary(locale, next)
+	if err != nil {
+		return nil, err
 	}
-	return "", fmt.Errorf("LLM 未返回有效摘要内容")
+	attrs["summary_length"] = len(body)
+	data.Event("LLMService", "Summarized", attrs)
+	return next, nil
+}
+
+// requestSummary 向 LLM 网关发起同步的摘要请求，给摘要任务较长的超时时间
+func (p *SummarizerPass) requestSummary(ctx context.Context, model, prompt string) (string, error) {
+	return chatCompletion(ctx, p.LLMServiceURL, model, prompt, 45*time.Second)
 }
@@ -4,17 +4,36 @@ import (
 	"context"
 	"context-fabric/backend/core/domain"
 	"context-fabric/backend/core/pipeline"
+	"context-fabric/backend/core/prompts"
+	"context-fabric/backend/core/util"
+	"fmt"
 	"time"
 )
 
+func init() {
+	pipeline.RegisterPass("SystemPromptPass", "注入系统提示词", struct{}{},
+		func(_ struct{}, deps pipeline.Dependencies) (pipeline.Pass, error) {
+			return NewSystemPromptPass().WithStore(deps.Prompts), nil
+		})
+}
+
 // SystemPromptPass 负责在消息列表的起始位置注入预设的系统提示词。
-type SystemPromptPass struct{}
+// 提示词按会话的 AppID 从模板库中选取并渲染，未配置模板时使用内置提示词。
+type SystemPromptPass struct {
+	store pipeline.PromptStore
+}
 
 // NewSystemPromptPass 创建一个 SystemPromptPass 实例。
 func NewSystemPromptPass() *SystemPromptPass {
 	return &SystemPromptPass{}
 }
 
+// WithStore 设置提示词模板库，为空时始终使用内置提示词。
+func (p *SystemPromptPass) WithStore(store pipeline.PromptStore) *SystemPromptPass {
+	p.store = store
+	return p
+}
+
 func (p *SystemPromptPass) Name() string {
 	return "SystemPromptPass"
 }
@@ -25,11 +44,28 @@ func (p *SystemPromptPass) Description() string {
 
 // Run 将包含系统状态和环境信息的提示词消息插入到列表头部。
 func (p *SystemPromptPass) Run(ctx context.Context, data *pipeline.ContextData) error {
+	vars := promptVars(data)
+	appID := vars.AppID
+
+	content, version := "", 0
+	var err error
+	if p.store != nil {
+		content, version, err = p.store.Render(ctx, appID, vars)
+	} else {
+		content, err = prompts.RenderBuiltin(vars)
+	}
+	if err != nil {
+		return fmt.Errorf("render system prompt for app %q: %w", appID, err)
+	}
+	data.SetAttr("prompt_app", appID)
+	data.SetAttr("prompt_version", version)
+
 	// 构建系统消息
 	sysMsg := domain.Message{
 		Role:      domain.RoleSystem,
-		Content:   "你是一个由 ContextFabric 驱动的智能助手。当前系统时间: " + time.Now().Format("15:04:05"),
+		Content:   content,
 		Timestamp: time.Now(),
+		Meta:      map[string]interface{}{domain.MetaLayer: domain.LayerSystem, domain.MetaPinned: true},
 	}
 
 	// 确保系统消息处于上下文的最顶层
@@ -37,3 +73,38 @@ func (p *SystemPromptPass) Run(ctx context.Context, data *pipeline.ContextData)
 
 	return nil
 }
+
+// promptVars 从管线元数据中收集模板变量。时区依次取请求的 timezone、环境变量 AGENTIC_TIMEZONE 与本地时区。
+func promptVars(data *pipeline.ContextData) domain.PromptVars {
+	loc := time.Local
+	tz, _ := data.Meta["timezone"].(string)
+	if tz == "" {
+		tz = util.GetEnv("AGENTIC_TIMEZONE", "")
+	}
+	if tz != "" {
+		if l, err := time.LoadLocation(tz); err == nil {
+			loc = l
+		} else {
+			data.Event("SystemPrompt", "InvalidTimezone", pipeline.Attrs{"timezone": tz, "error": err.Error()})
+		}
+	}
+	now := time.Now().In(loc)
+
+	vars := domain.PromptVars{
+		Now:       now,
+		Time:      now.Format("15:04:05"),
+		Date:      now.Format("2006-01-02"),
+		Timezone:  loc.String(),
+		SessionID: data.SessionID,
+		User:      map[string]string{},
+	}
+	vars.AppID, _ = data.Meta["app_id"].(string)
+	vars.SessionName, _ = data.Meta["session_name"].(string)
+	vars.ModelID, _ = data.Meta["model_id"].(string)
+	if profile, ok := data.Meta["user_profile"].(map[string]string); ok {
+		vars.User = profile
+	}
+	vars.MemoryCount, _ = data.Meta["memory_count"].(int)
+	vars.FactCount, _ = data.Meta["fact_count"].(int)
+	return vars
+}
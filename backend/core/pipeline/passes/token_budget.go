@@ -0,0 +1,255 @@
+package passes
+
+import (
+	"context-fabric/backend/core/domain"
+	"context-fabric/backend/core/pipeline"
+	"context-fabric/backend/core/prompts"
+	"context-fabric/backend/core/tokens"
+	"sort"
+)
+
+// defaultSpill 是各层剩余配额的默认补充顺序：优先补足对话历史，其次摘要与检索内容。
+var defaultSpill = []string{domain.LayerHistory, domain.LayerSummary, domain.LayerRAG, domain.LayerMemory, domain.LayerSystem}
+
+func knownLayer(layer string) bool {
+	for _, l := range domain.Layers {
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedLayers(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// layerOf 返回消息所属的层，未知的自定义层按对话历史处理。
+func layerOf(m domain.Message) string {
+	if layer := domain.LayerOf(m); knownLayer(layer) {
+		return layer
+	}
+	return domain.LayerHistory
+}
+
+// budget 记录截断过程中每条消息的 Token 数与保留标记。
+type budget struct {
+	msgs     []domain.Message
+	counts   []int
+	keep     []bool
+	counter  *tokens.Counter
+	trimMode string
+	minTrim  int
+}
+
+// newBudget 基于消息列表的副本创建预算状态，消息内截断不会改动原始消息。
+func (p *TokenLimitPass) newBudget(msgs []domain.Message, counter *tokens.Counter) *budget {
+	b := &budget{
+		msgs:     append([]domain.Message(nil), msgs...),
+		counts:   make([]int, len(msgs)),
+		keep:     make([]bool, len(msgs)),
+		counter:  counter,
+		trimMode: p.trim,
+		minTrim:  p.minTrim,
+	}
+	for i, m := range msgs {
+		b.counts[i] = counter.CountMessage(m)
+	}
+	return b
+}
+
+// pinnedTokens 标记所有固定消息以及最后一条用户消息（当前提问）为保留，返回其 Token 总数。
+// 固定消息超出预算时仍会保留，由调用方记录 PinnedOverBudget 事件。
+func (b *budget) pinnedTokens() int {
+	for i := len(b.msgs) - 1; i >= 0; i-- {
+		if b.msgs[i].Role == domain.RoleUser {
+			b.keep[i] = true
+			break
+		}
+	}
+	total := 0
+	for i, m := range b.msgs {
+		if domain.IsPinned(m) {
+			b.keep[i] = true
+		}
+		if b.keep[i] {
+			total += b.counts[i]
+		}
+	}
+	return total
+}
+
+// trim 在开启消息内截断时，尝试将第 i 条消息（含消息开销）压缩到 room 以内。
+// 成功时替换消息内容（Meta 标记 truncated 与原始 Token 数）并更新计数，由调用方负责保留与扣减。
+func (b *budget) trim(data *pipeline.ContextData, i, room int) bool {
+	if b.trimMode == "" || b.trimMode == tokens.TrimNone || room < b.minTrim || room <= 0 {
+		return false
+	}
+	overhead := b.counter.Spec.MessageOverhead
+	content := b.counter.TrimWith(b.msgs[i].Content, room-overhead, b.trimMode, trimMarkers(localeOf(data)))
+	n := b.counter.Count(content) + overhead
+	if content == "" || n > room {
+		return false
+	}
+
+	m := b.msgs[i]
+	meta := make(map[string]interface{}, len(m.Meta)+2)
+	for k, v := range m.Meta {
+		meta[k] = v
+	}
+	meta["truncated"] = true
+	meta["original_tokens"] = b.counts[i]
+	m.Content, m.Meta = content, meta
+
+	data.Event("Messages", "TrimMessage", pipeline.Attrs{
+		"msg_index":       i,
+		"original_tokens": b.counts[i],
+		"tokens":          n,
+		"mode":            b.trimMode,
+	})
+	b.msgs[i] = m
+	b.counts[i] = n
+	return true
+}
+
+// trimMarkers 以会话语言的 elided / elided_code 注入模板渲染截断标记，渲染失败时使用默认标记。
+func trimMarkers(locale string) tokens.Markers {
+	return tokens.Markers{
+		Elided: func(n int) string {
+			if s, err := prompts.Inject(locale, prompts.InjectElided, prompts.ElidedData{Tokens: n}); err == nil {
+				return s
+			}
+			return tokens.DefaultMarkers.Elided(n)
+		},
+		ElidedCode: func(n int) string {
+			if s, err := prompts.Inject(locale, prompts.InjectElidedCode, prompts.ElidedData{Lines: n}); err == nil {
+				return s
+			}
+			return tokens.DefaultMarkers.ElidedCode(n)
+		},
+	}
+}
+
+// candidates 返回尚未保留的消息下标。
+func (b *budget) candidates() []int {
+	var idx []int
+	for i := range b.msgs {
+		if !b.keep[i] {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// byPriority 将候选消息按保留偏好排序：优先级高者在前，同优先级较新的在前。
+func (b *budget) byPriority(idx []int) []int {
+	sort.SliceStable(idx, func(x, y int) bool {
+		px, py := domain.PriorityOf(b.msgs[idx[x]]), domain.PriorityOf(b.msgs[idx[y]])
+		if px != py {
+			return px > py
+		}
+		return idx[x] > idx[y]
+	})
+	return idx
+}
+
+// apply 按原有顺序输出保留的消息，并为每条被丢弃的消息记录 Truncate 事件。
+func (b *budget) apply(data *pipeline.ContextData) {
+	selected := make([]domain.Message, 0, len(b.msgs))
+	for i, m := range b.msgs {
+		if b.keep[i] {
+			selected = append(selected, m)
+			continue
+		}
+		data.Event("Messages", "Truncate", pipeline.Attrs{
+			"dropped_msg_index": i,
+			"msg_length":        b.counts[i],
+			"layer":             layerOf(m),
+			"priority":          domain.PriorityOf(m),
+		})
+	}
+	data.Messages = selected
+}
+
+// allocate 按层分配 Token 预算。
+//
+//  1. 可用预算 limit = max_tokens - reserve_response，固定消息与当前提问始终保留并先行扣除；
+//  2. 各层配额 = 剩余预算 × 配置比例，层内按优先级、同优先级从新到旧择优保留；
+//  3. 各层未用完的配额与未分配比例的预算汇入溢出池，按 spill 顺序补充此前放不下的消息。
+//
+// 开启消息内截断时，放不下的消息会先尝试压缩到剩余空间内。
+//
+// 保留的消息维持原有相对顺序。
+func (p *TokenLimitPass) allocate(data *pipeline.ContextData, counter *tokens.Counter, limit int) {
+	b := p.newBudget(data.Messages, counter)
+	available := limit - b.pinnedTokens()
+	if available < 0 {
+		data.Event("Messages", "PinnedOverBudget", pipeline.Attrs{
+			"over_tokens": -available,
+		})
+		available = 0
+	}
+
+	// 按层归集候选消息
+	candidates := make(map[string][]int)
+	for _, i := range b.candidates() {
+		layer := layerOf(b.msgs[i])
+		candidates[layer] = append(candidates[layer], i)
+	}
+	for layer, idx := range candidates {
+		candidates[layer] = b.byPriority(idx)
+	}
+
+	quotas := make(map[string]int, len(domain.Layers))
+	used := make(map[string]int, len(domain.Layers))
+	pool := available
+	for _, layer := range domain.Layers {
+		quotas[layer] = int(float64(available) * p.layers[layer])
+		pool -= quotas[layer]
+	}
+
+	// 第一轮：各层在自身配额内保留消息
+	for _, layer := range domain.Layers {
+		for _, i := range candidates[layer] {
+			if used[layer]+b.counts[i] <= quotas[layer] || b.trim(data, i, quotas[layer]-used[layer]) {
+				b.keep[i] = true
+				used[layer] += b.counts[i]
+			}
+		}
+		pool += quotas[layer] - used[layer]
+	}
+
+	// 第二轮：剩余配额按 spill 顺序补充
+	for _, layer := range p.spill {
+		for _, i := range candidates[layer] {
+			if !b.keep[i] && (b.counts[i] <= pool || b.trim(data, i, pool)) {
+				b.keep[i] = true
+				used[layer] += b.counts[i]
+				pool -= b.counts[i]
+			}
+		}
+	}
+
+	b.apply(data)
+	data.SetAttr("layer_quotas", quotas)
+}
+
+// reportLayerUsage 统计最终消息列表中各层实际占用的 Token（含消息开销），写入 tokens_<layer> 与 tokens_total。
+func reportLayerUsage(data *pipeline.ContextData, counter *tokens.Counter) {
+	usage := make(map[string]int, len(domain.Layers))
+	total := 0
+	for _, m := range data.Messages {
+		t := counter.CountMessage(m)
+		usage[layerOf(m)] += t
+		total += t
+	}
+	for _, layer := range domain.Layers {
+		data.Meta["tokens_"+layer] = usage[layer]
+	}
+	data.Meta["tokens_total"] = total
+}
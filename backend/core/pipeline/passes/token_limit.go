@@ -4,27 +4,108 @@ import (
 	"context"
 	"context-fabric/backend/core/domain"
 	"context-fabric/backend/core/pipeline"
-
-	"github.com/pkoukk/tiktoken-go"
+	"context-fabric/backend/core/tokens"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
+// TokenLimitConfig 是 TokenLimitPass 的可配置参数。
+type TokenLimitConfig struct {
+	MaxTokens       int                `json:"max_tokens"`       // 上下文上限，0 表示跟随所选模型的上下文窗口；不会超过模型窗口
+	ReserveResponse int                `json:"reserve_response"` // 为模型回复预留的 Token 数，跟随模型且未设置时取模型的最大输出
+	Layers          map[string]float64 `json:"layers"`           // 各层配额占可用 Token 的比例，留空时按整体上限截断
+	Spill           []string           `json:"spill"`            // 各层剩余配额的补充顺序，留空使用 defaultSpill
+	Trim            string             `json:"trim"`             // 放不下的消息的处理方式：none 整条丢弃，middle 保留首尾，code 保持代码块完整
+	MinTrimTokens   int                `json:"min_trim_tokens"`  // 剩余空间低于该值时不做消息内截断
+}
+
+func (c *TokenLimitConfig) Validate() error {
+	var errs []error
+	if c.MaxTokens < 0 {
+		errs = append(errs, fmt.Errorf("max_tokens must not be negative, got %d", c.MaxTokens))
+	}
+	if c.ReserveResponse < 0 || (c.MaxTokens > 0 && c.ReserveResponse >= c.MaxTokens) {
+		errs = append(errs, fmt.Errorf("reserve_response must be in [0, max_tokens), got %d", c.ReserveResponse))
+	}
+	sum := 0.0
+	for _, layer := range sortedLayers(c.Layers) {
+		ratio := c.Layers[layer]
+		if !knownLayer(layer) {
+			errs = append(errs, fmt.Errorf("layers: unknown layer %q (known: %v)", layer, domain.Layers))
+		}
+		if ratio < 0 || ratio > 1 {
+			errs = append(errs, fmt.Errorf("layers.%s: ratio must be between 0 and 1, got %v", layer, ratio))
+		}
+		sum += ratio
+	}
+	if sum > 1+1e-9 {
+		errs = append(errs, fmt.Errorf("layers: ratios sum to %.2f, must not exceed 1", sum))
+	}
+	for _, layer := range c.Spill {
+		if !knownLayer(layer) {
+			errs = append(errs, fmt.Errorf("spill: unknown layer %q (known: %v)", layer, domain.Layers))
+		}
+	}
+	switch c.Trim {
+	case tokens.TrimNone, tokens.TrimMiddle, tokens.TrimCode:
+	default:
+		errs = append(errs, fmt.Errorf("trim must be one of %q, %q, %q, got %q", tokens.TrimNone, tokens.TrimMiddle, tokens.TrimCode, c.Trim))
+	}
+	if c.MinTrimTokens < 0 {
+		errs = append(errs, fmt.Errorf("min_trim_tokens must not be negative, got %d", c.MinTrimTokens))
+	}
+	return errors.Join(errs...)
+}
+
+func init() {
+	// 默认跟随所选模型的上下文窗口
+	pipeline.RegisterPass("TokenLimitPass", "Token 限制与截断", TokenLimitConfig{Trim: tokens.TrimNone, MinTrimTokens: 64},
+		func(cfg TokenLimitConfig, _ pipeline.Dependencies) (pipeline.Pass, error) {
+			return NewTokenLimitPass(cfg.MaxTokens).
+				WithBudget(cfg.ReserveResponse, cfg.Layers, cfg.Spill).
+				WithTrim(cfg.Trim, cfg.MinTrimTokens), nil
+		})
+}
+
 // TokenLimitPass 负责执行上下文的截断策略。
 // 当消息总长度超过模型限制时，它会按照一定的规则保留关键消息。
 type TokenLimitPass struct {
-	tke       *tiktoken.Tiktoken
 	maxTokens int
+	reserve   int
+	layers    map[string]float64
+	spill     []string
+	trim      string
+	minTrim   int
 }
 
-// NewTokenLimitPass 创建一个带有 Token 限制的截断处理器。
+// NewTokenLimitPass 创建一个带有 Token 限制的截断处理器，maxTokens 为 0 时跟随模型的上下文窗口。
 func NewTokenLimitPass(maxTokens int) *TokenLimitPass {
-	// 初始化 tiktoken 编码器 (针对 cl100k_base 优化)
-	tke, _ := tiktoken.GetEncoding("cl100k_base")
 	return &TokenLimitPass{
-		tke:       tke,
 		maxTokens: maxTokens,
 	}
 }
 
+// WithBudget 设置回复预留与分层配额；layers 为空时仍按整体上限截断。
+func (p *TokenLimitPass) WithBudget(reserve int, layers map[string]float64, spill []string) *TokenLimitPass {
+	p.reserve = reserve
+	p.layers = layers
+	p.spill = spill
+	if len(p.spill) == 0 {
+		p.spill = defaultSpill
+	}
+	return p
+}
+
+// WithTrim 开启消息内截断：单条消息超出剩余空间时按 mode 压缩而非整条丢弃。
+func (p *TokenLimitPass) WithTrim(mode string, minTokens int) *TokenLimitPass {
+	p.trim = mode
+	p.minTrim = minTokens
+	return p
+}
+
 func (p *TokenLimitPass) Name() string {
 	return "TokenLimitPass"
 }
@@ -33,77 +114,146 @@ func (p *TokenLimitPass) Description() string {
 	return "Token 限制与截断"
 }
 
-// Run 执行截断逻辑：保留首条系统消息，并从后往前尝试保留最近的历史消息，超出 Token 上限的消息将被跳过。
+// Run 执行截断逻辑。计数口径与上限由 Meta 中的 model_id 在模型目录中查得；
+// 配置了分层配额时按层分配预算，否则按优先级整体截断；
+// 两种模式都不会丢弃固定消息，并在 Meta 中汇报各层实际用量，引用列表随 RAG 消息的去留同步裁剪。
 func (p *TokenLimitPass) Run(ctx context.Context, data *pipeline.ContextData) error {
-	// 内部工具函数：计算文本占用的 Token 数
-	estimate := func(s string) int {
-		if p.tke == nil {
-			return len(s) / 4
-		}
-		tokens := p.tke.Encode(s, nil, nil)
-		return len(tokens)
-	}
-
 	if len(data.Messages) == 0 {
 		return nil
 	}
-
-	// 截断策略：始终保留第一条系统消息 (System Message)，
-	// 其余消息（用户/助理对话历史）按照时间倒序尝试加入，直到填满配额。
-	var sysMsg domain.Message
-	hasSysMsg := false
-	var otherMsgs []domain.Message
-
-	if data.Messages[0].Role == domain.RoleSystem {
-		sysMsg = data.Messages[0]
-		hasSysMsg = true
-		otherMsgs = data.Messages[1:]
+	modelID, _ := data.Meta["model_id"].(string)
+	counter := tokens.ForModel(modelID)
+	maxTokens, reserve := p.limits(counter.Spec)
+	if len(p.layers) > 0 {
+		p.allocate(data, counter, maxTokens-reserve)
 	} else {
-		otherMsgs = data.Messages
+		p.truncate(data, counter, maxTokens-reserve)
 	}
+	pruneCitations(data)
+	reportLayerUsage(data, counter)
+	data.Meta["tokens_max"] = maxTokens
+	data.Meta["tokens_reserved"] = reserve
+	data.Meta["tokenizer"] = counter.Spec.Encoding
+	data.SetAttr("model_spec", counter.Spec)
+	return nil
+}
 
-	currentTokens := 0
-	if hasSysMsg {
-		currentTokens = estimate(sysMsg.Content)
+// limits 结合配置与模型规格计算实际的上下文上限与回复预留：
+// 未配置 max_tokens 时取模型上下文窗口，配置值也不会超过窗口；
+// 跟随模型且未配置 reserve_response 时预留模型的最大输出。
+// 上限被收紧到窗口时预留按同一比例缩小，且预留始终小于上限，保证可用预算为正。
+func (p *TokenLimitPass) limits(spec tokens.ModelSpec) (maxTokens, reserve int) {
+	maxTokens, reserve = p.maxTokens, p.reserve
+	if maxTokens <= 0 || maxTokens > spec.ContextWindow {
+		maxTokens = spec.ContextWindow
 	}
+	if p.maxTokens <= 0 && reserve == 0 {
+		reserve = spec.MaxOutput
+	}
+	if p.maxTokens > maxTokens {
+		reserve = reserve * maxTokens / p.maxTokens
+	}
+	if reserve >= maxTokens {
+		reserve = maxTokens / 2
+	}
+	return maxTokens, reserve
+}
 
-	var selected []domain.Message
-
-	// 从最近的消息开始倒序遍历
-	for i := len(otherMsgs) - 1; i >= 0; i-- {
-		msg := otherMsgs[i]
-		t := estimate(msg.Content)
+// truncate 整体截断：固定消息（系统提示词、摘要、当前提问等）始终保留，
+// 其余消息按优先级从高到低、同优先级从新到旧依次尝试加入，放不下的消息被丢弃。
+func (p *TokenLimitPass) truncate(data *pipeline.ContextData, counter *tokens.Counter, limit int) {
+	b := p.newBudget(data.Messages, counter)
+	pool := limit - b.pinnedTokens()
+	if pool < 0 {
+		data.Event("Messages", "PinnedOverBudget", pipeline.Attrs{
+			"over_tokens": -pool,
+		})
+	}
+	for _, i := range b.byPriority(b.candidates()) {
+		if b.counts[i] <= pool || b.trim(data, i, pool) {
+			b.keep[i] = true
+			pool -= b.counts[i]
+		}
+	}
+	b.apply(data)
+}
 
-		// 检查是否超出配额
-		if currentTokens+t > p.maxTokens {
-			// 记录由于截断而被丢弃的消息轨迹
-			data.Traces = append(data.Traces, map[string]interface{}{
-				"source": "TokenLimitPass",
-				"target": "Messages",
-				"action": "Truncate",
-				"data": map[string]interface{}{
-					"dropped_msg_index": i,
-					"msg_length":        t,
-				},
-			})
+// pruneCitations 使引用列表与截断后保留的 RAG 片段一致：RAG 消息被丢弃时移除其全部引用，
+// 被截断时只保留编号标记 [n] 仍位于某行行首的引用；消息 Meta、Meta 中的引用列表与 rag_context 同步更新。
+func pruneCitations(data *pipeline.ContextData) {
+	before, ok := data.Meta[domain.MetaCitations].([]domain.Citation)
+	if !ok {
+		return
+	}
+	var kept []domain.Citation
+	for i, m := range data.Messages {
+		citations, ok := m.Meta[domain.MetaCitations].([]domain.Citation)
+		if !ok || layerOf(m) != domain.LayerRAG {
 			continue
 		}
-
-		// 加入选中列表并更新计数
-		selected = append([]domain.Message{msg}, selected...)
-		currentTokens += t
+		if truncated, _ := m.Meta["truncated"].(bool); truncated {
+			citations = citedIn(m.Content, citations)
+			meta := make(map[string]interface{}, len(m.Meta))
+			for k, v := range m.Meta {
+				meta[k] = v
+			}
+			meta[domain.MetaCitations] = citations
+			data.Messages[i].Meta = meta
+		}
+		kept = append(kept, citations...)
 	}
-
-	// 重组最终的消息列表
-	if hasSysMsg {
-		data.Messages = append([]domain.Message{sysMsg}, selected...)
+	if len(kept) == len(before) {
+		return
+	}
+	if len(kept) == 0 {
+		delete(data.Meta, domain.MetaCitations)
+		delete(data.Meta, "rag_context")
 	} else {
-		data.Messages = selected
+		data.Meta[domain.MetaCitations] = kept
+		if rc, ok := data.Meta["rag_context"].(string); ok {
+			data.Meta["rag_context"] = pruneRAGContext(rc, kept)
+		}
 	}
+	data.Event("Messages", "CitationsPruned", pipeline.Attrs{
+		"dropped": len(before) - len(kept),
+		"kept":    len(kept),
+	})
+}
 
-	// 更新元数据，反馈给前端统计
-	data.Meta["tokens_total"] = currentTokens
-	data.Meta["tokens_max"] = p.maxTokens
+// citedIn 返回编号标记仍出现在正文某行行首的引用。
+func citedIn(content string, citations []domain.Citation) []domain.Citation {
+	var out []domain.Citation
+	for _, c := range citations {
+		marker := "[" + strconv.Itoa(c.Index) + "]"
+		if strings.HasPrefix(content, marker) || strings.Contains(content, "\n"+marker) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
 
-	return nil
+// pruneRAGContext 只保留 rag_context 中编号在 kept 里的片段。每个片段以 "---\n[n] " 开头（见 RAGPass），
+// 不以编号开头的 "---" 行属于片段正文。
+func pruneRAGContext(ragContext string, kept []domain.Citation) string {
+	keep := make(map[string]bool, len(kept))
+	for _, c := range kept {
+		keep["["+strconv.Itoa(c.Index)+"]"] = true
+	}
+	var sb strings.Builder
+	current := false
+	for i, piece := range strings.Split(ragContext, "---\n") {
+		if i == 0 {
+			continue // 首个片段之前没有内容
+		}
+		if marker, _, ok := strings.Cut(piece, " "); ok && ragMarker.MatchString(marker) {
+			current = keep[marker]
+		}
+		if current {
+			sb.WriteString("---\n" + piece)
+		}
+	}
+	return sb.String()
 }
+
+// ragMarker 匹配片段开头的编号标记。
+var ragMarker = regexp.MustCompile(`^\[\d+\]$`)
@@ -0,0 +1,91 @@
+package passes
+
+import (
+	"context-fabric/backend/core/domain"
+	"context-fabric/backend/core/pipeline"
+	"reflect"
+	"testing"
+)
+
+func TestPruneCitations(t *testing.T) {
+	citations := []domain.Citation{{Index: 1, DocID: "a"}, {Index: 2, DocID: "b"}, {Index: 3, DocID: "c"}}
+	rag := func(content string, truncated bool) domain.Message {
+		meta := map[string]interface{}{domain.MetaLayer: domain.LayerRAG, domain.MetaCitations: citations}
+		if truncated {
+			meta["truncated"] = true
+		}
+		return domain.Message{Role: domain.RoleSystem, Content: content, Meta: meta}
+	}
+	question := domain.Message{Role: domain.RoleUser, Content: "question"}
+	full := "引用时标注编号（如 [1]）：\n\n[1] A\nalpha\n\n[2] B\nbeta\n\n[3] C\ngamma\n\n"
+	// RAGPass 写入的 rag_context，片段 2 的正文中含有 Markdown 分隔线
+	ragContext := "---\n[1] alpha\n---\n[2] beta\n---\nmore beta\n---\n[3] gamma\n"
+
+	tests := []struct {
+		name        string
+		msgs        []domain.Message
+		want        []int // nil 表示 Meta 中不再有引用
+		wantContext string
+	}{
+		{"kept intact", []domain.Message{rag(full, false), question}, []int{1, 2, 3}, ragContext},
+		{"dropped", []domain.Message{question}, nil, ""},
+		// 提示语中的示例 [1] 不在行首，不算引用
+		{"trimmed middle", []domain.Message{rag("引用时标注编号（如 [1]）：\n\n… 已省略 …\n\n[3] C\ngamma\n\n", true), question}, []int{3}, "---\n[3] gamma\n"},
+		{"trimmed tail", []domain.Message{rag("引用时标注编号（如 [1]）：\n\n[1] A\nalpha\n\n[2] B\nbe… 已省略 …", true), question}, []int{1, 2}, "---\n[1] alpha\n---\n[2] beta\n---\nmore beta\n"},
+		{"trimmed to nothing", []domain.Message{rag("引用时标注编号（如 [1]）：…", true), question}, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := &pipeline.ContextData{
+				Messages: tt.msgs,
+				Meta:     map[string]interface{}{domain.MetaCitations: citations, "rag_context": ragContext},
+			}
+			pruneCitations(data)
+
+			rc, _ := data.Meta["rag_context"].(string)
+			if rc != tt.wantContext {
+				t.Errorf("rag_context = %q, want %q", rc, tt.wantContext)
+			}
+
+			got, ok := data.Meta[domain.MetaCitations].([]domain.Citation)
+			if tt.want == nil {
+				if ok {
+					t.Fatalf("citations = %+v, want none", got)
+				}
+				return
+			}
+			var indexes []int
+			for _, c := range got {
+				indexes = append(indexes, c.Index)
+			}
+			if !reflect.DeepEqual(indexes, tt.want) {
+				t.Fatalf("citations = %v, want %v", indexes, tt.want)
+			}
+			if inMsg := data.Messages[0].Meta[domain.MetaCitations]; !reflect.DeepEqual(inMsg, got) {
+				t.Errorf("message citations %+v differ from Meta %+v", inMsg, got)
+			}
+		})
+	}
+	if len(citations) != 3 {
+		t.Errorf("original citation list was modified: %+v", citations)
+	}
+}
+
+func TestTrimMarkersFollowLocale(t *testing.T) {
+	tests := []struct {
+		locale, elided, code string
+	}{
+		{"en", "\n…[12 tokens omitted]…\n", "\n…[code block of 3 lines omitted]…\n"},
+		{"zh", "\n…[已省略 12 个 Token]…\n", "\n…[已省略代码块 3 行]…\n"},
+		{"unknown", "\n…[已省略 12 个 Token]…\n", "\n…[已省略代码块 3 行]…\n"},
+	}
+	for _, tt := range tests {
+		m := trimMarkers(tt.locale)
+		if got := m.Elided(12); got != tt.elided {
+			t.Errorf("%s: Elided = %q, want %q", tt.locale, got, tt.elided)
+		}
+		if got := m.ElidedCode(3); got != tt.code {
+			t.Errorf("%s: ElidedCode = %q, want %q", tt.locale, got, tt.code)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package pipeline
 
 import (
 	"context"
-	"context-fabric/backend/core/domain"
 	"fmt"
 	"time"
 )
@@ -10,92 +9,164 @@ import (
 // Pipeline 管理一组有序执行的 Pass 处理单元。
 // 它负责协调上下文数据的流转，并记录每一步的执行踪迹。
 type Pipeline struct {
-	passes []Pass
+	name  string
+	steps []Step
 }
 
-// NewPipeline 创建一个新的 Pipeline 实例。
-func NewPipeline(passes ...Pass) *Pipeline {
+// Step 是管线中的一个顺序步骤：单个 Stage，或一组并发执行的独立 Stage。
+type Step struct {
+	Stages []Stage
+}
+
+// Sequential 创建只包含一个 Stage 的普通步骤。
+func Sequential(stage Stage) Step {
+	return Step{Stages: []Stage{stage}}
+}
+
+// Parallel 创建并发执行的步骤，各 Stage 的输出按声明顺序确定性地合并。
+func Parallel(stages ...Stage) Step {
+	return Step{Stages: stages}
+}
+
+// NewPipeline 创建一个新的 Pipeline 实例，各 Pass 顺序执行并使用其声明的默认策略与守卫条件。
+func NewPipeline(passes ...Pass) (*Pipeline, error) {
+	steps := make([]Step, len(passes))
+	for i, pass := range passes {
+		stage, err := NewStage(pass)
+		if err != nil {
+			return nil, err
+		}
+		steps[i] = Sequential(stage)
+	}
+	return &Pipeline{
+		steps: steps,
+	}, nil
+}
+
+// NewNamedPipeline 创建一个带 Profile 名称的 Pipeline 实例，名称会记录在 Trace 中。
+func NewNamedPipeline(name string, steps ...Step) *Pipeline {
 	return &Pipeline{
-		passes: passes,
+		name:  name,
+		steps: steps,
 	}
 }
 
-// Execute 依次执行管线中注册的所有 Pass。
-// 它会初始化上下文环境，并为每个 Pass 生成包含消息快照的 Trace 记录。
-func (p *Pipeline) Execute(ctx context.Context, data *ContextData) error {
-	totalStart := time.Now()
+// Name 返回管线对应的 Profile 名称。
+func (p *Pipeline) Name() string {
+	return p.name
+}
 
+// Execute 依次执行管线中的所有步骤。
+// 它会初始化上下文环境，按各 Pass 的策略处理超时、重试与失败，并为每个 Pass 记录包含执行结果与消息差异的 Span。
+func (p *Pipeline) Execute(ctx context.Context, data *ContextData) error {
 	// 初始化元数据和追踪容器
 	if data.Meta == nil {
 		data.Meta = make(map[string]interface{})
 	}
-	if data.Traces == nil {
-		data.Traces = make([]map[string]interface{}, 0)
+	if data.Trace == nil {
+		data.Trace = NewTrace("Pipeline")
 	}
+	if data.embeddings == nil {
+		data.embeddings = newEmbeddingCache()
+	}
+
+	passCount := 0
+	for _, step := range p.steps {
+		passCount += len(step.Stages)
+	}
+
+	// 根 Span 记录管线级信息
+	root := data.Trace.Root
+	root.SetAttr("session_id", data.SessionID)
+	root.SetAttr("profile", p.name)
+	root.SetAttr("pass_count", passCount)
 
-	// 记录 Pipeline 启动事件
-	data.Traces = append(data.Traces, map[string]interface{}{
-		"source": "Core",
-		"target": "Pipeline",
-		"action": "Start",
-		"data": map[string]interface{}{
-			"session_id": data.SessionID,
-			"pass_count": len(p.passes),
-		},
-	})
-
-	// 循环执行每一个处理步骤 (Pass)
-	for _, pass := range p.passes {
-		start := time.Now()
-		passName := pass.Name()
-		passDesc := pass.Description()
-
-		// 执行具体的业务逻辑
-		if err := pass.Run(ctx, data); err != nil {
-			return fmt.Errorf("pass %s failed: %w", passName, err)
+	// 循环执行每一个处理步骤
+	for i, step := range p.steps {
+		var err error
+		if len(step.Stages) == 1 {
+			err = p.runSequential(ctx, step.Stages[0], data)
+		} else {
+			err = p.runParallel(ctx, i, step.Stages, data)
 		}
+		if err != nil {
+			return err
+		}
+	}
 
-		duration := time.Since(start).Milliseconds()
-
-		// 记录 Pass 执行完成的 Trace，并捕获当前的消息列表快照
-		data.Traces = append(data.Traces, map[string]interface{}{
-			"source": "Pipeline",
-			"target": passName,
-			"action": "Complete",
-			"data": map[string]interface{}{
-				"description": passDesc,
-				"is_pass":     true,
-				"pass_name":   passName,
-				"duration_ms": duration,
-				"msg_count":   len(data.Messages),
-				"messages":    cloneMessages(data.Messages),
-			},
-		})
+	// 汇总本次运行的向量缓存命中情况
+	embStats := data.EmbeddingStats()
+	if embStats.Hits+embStats.Misses > 0 {
+		data.Meta["embedding_cache"] = embStats
 	}
 
-	// 记录整个管线完成的事件
-	totalDuration := time.Since(totalStart).Milliseconds()
-	data.Traces = append(data.Traces, map[string]interface{}{
-		"source": "Core",
-		"target": "Pipeline",
-		"action": "Finished",
-		"data": map[string]interface{}{
-			"total_duration_ms": totalDuration,
-		},
-	})
+	data.Trace.Finish(root, SpanComplete)
+	root.SetAttr("total_duration_ms", root.Duration().Milliseconds())
+	root.SetAttr("embedding_cache", embStats)
 
 	return nil
 }
 
-// cloneMessages 将消息列表转换为适合 Trace 记录的快照映射（投影）。
-// 这确保了记录的是该时刻的静态内容，不会受到后续 Pass 对原始消息对象修改的影响。
-func cloneMessages(msgs []domain.Message) []interface{} {
-	cloned := make([]interface{}, len(msgs))
-	for i, m := range msgs {
-		cloned[i] = map[string]interface{}{
-			"role":    m.Role,
-			"content": m.Content,
-		}
+// runSequential 执行单个 Stage 并记录其 Span。
+func (p *Pipeline) runSequential(ctx context.Context, stage Stage, data *ContextData) error {
+	span := data.Trace.StartSpan(stage.Pass.Name())
+
+	// 求值守卫条件，内置变量在每个 Pass 执行前按当前消息列表计算
+	if ok, reason := EvalConditions(stage.When, data.Meta, builtinVars(data)); !ok {
+		p.finishSkipped(data.Trace, span, stage, reason, data)
+		return nil
+	}
+
+	// 按策略执行具体的业务逻辑，Pass 内部事件写入当前 Span
+	data.span = span
+	res, err := stage.run(ctx, data)
+	data.span = nil
+	p.finishComplete(data.Trace, span, stage, res, data)
+	return p.stageError(stage, res, err)
+}
+
+// builtinVars 返回守卫条件可引用的内置变量，它们不写入 Meta，因此不会随消息持久化。
+func builtinVars(data *ContextData) map[string]interface{} {
+	return map[string]interface{}{"msg_count": len(data.Messages)}
+}
+
+// passAttrs 设置所有 Pass Span 共有的属性。
+func (p *Pipeline) passAttrs(span *Span, stage Stage, data *ContextData) {
+	span.SetAttr("description", stage.Pass.Description())
+	span.SetAttr("is_pass", true)
+	span.SetAttr("pass_name", stage.Pass.Name())
+	span.SetAttr("profile", p.name)
+	span.SetAttr("msg_count", len(data.Messages))
+}
+
+// finishSkipped 结束因守卫条件未满足而跳过的 Pass Span。
+func (p *Pipeline) finishSkipped(trace *Trace, span *Span, stage Stage, reason string, data *ContextData) {
+	p.passAttrs(span, stage, data)
+	span.SetAttr("outcome", OutcomeSkipped)
+	span.SetAttr("reason", reason)
+	trace.Finish(span, SpanSkipped)
+}
+
+// finishComplete 结束执行完成的 Pass Span，记录执行结果以及消息列表相对上一个 Pass 的差异。
+// 完整快照可通过 ReplaySnapshot 按需重建，避免每个 Pass 都持久化整段会话。
+func (p *Pipeline) finishComplete(trace *Trace, span *Span, stage Stage, res stageResult, data *ContextData) {
+	if span.End.IsZero() {
+		span.End = time.Now()
+	}
+	p.passAttrs(span, stage, data)
+	span.SetAttr("duration_ms", span.Duration().Milliseconds())
+	span.SetAttr("outcome", res.outcome)
+	span.SetAttr("attempts", res.attempts)
+	span.SetAttr("diff", trace.RecordMessages(data.Messages))
+	if res.err != nil {
+		span.SetAttr("error", res.err.Error())
+	}
+	trace.Finish(span, SpanComplete)
+}
+
+func (p *Pipeline) stageError(stage Stage, res stageResult, err error) error {
+	if err == nil {
+		return nil
 	}
-	return cloned
+	return fmt.Errorf("pass %s failed (%s after %d attempts): %w", stage.Pass.Name(), res.outcome, res.attempts, err)
 }
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrorMode 决定 Pass 最终失败后管线的处理方式。
+type ErrorMode string
+
+const (
+	// OnErrorFail 立即中断整个管线（默认行为）。
+	OnErrorFail ErrorMode = "fail"
+	// OnErrorSkip 丢弃该 Pass 的修改并继续执行后续 Pass。
+	OnErrorSkip ErrorMode = "skip"
+)
+
+// Pass 执行结果，记录在 Complete Trace 的 outcome 字段中。
+const (
+	OutcomeOK      = "ok"
+	OutcomeRetried = "retried"
+	OutcomeSkipped = "skipped"
+	OutcomeTimeout = "timeout"
+	OutcomeFailed  = "failed"
+)
+
+// defaultBackoff 是开启重试但未配置 backoff_ms 时的首次退避时长。
+const defaultBackoff = 200 * time.Millisecond
+
+// Policy 描述单个 Pass 的容错策略。
+// Retries 与 OnError 相互独立：先按退避重试，重试耗尽后再按 OnError 决定中断或跳过。
+type Policy struct {
+	OnError   ErrorMode `json:"on_error,omitempty"`
+	Retries   int       `json:"retries,omitempty"`    // 失败后的重试次数
+	BackoffMs int       `json:"backoff_ms,omitempty"` // 首次重试前的等待时间，之后按指数递增
+	TimeoutMs int       `json:"timeout_ms,omitempty"` // 单次执行的超时时间，0 表示不限制
+}
+
+// PolicyProvider 可由 Pass 实现，用于声明其默认的容错策略。
+// 配置文件中的 policy 字段会在默认策略的基础上逐项覆盖。
+type PolicyProvider interface {
+	DefaultPolicy() Policy
+}
+
+// Validate 检查策略取值是否合法。
+func (p Policy) Validate() error {
+	var errs []error
+	switch p.OnError {
+	case "", OnErrorFail, OnErrorSkip:
+	default:
+		errs = append(errs, fmt.Errorf("on_error must be %q or %q, got %q", OnErrorFail, OnErrorSkip, p.OnError))
+	}
+	if p.Retries < 0 || p.Retries > 10 {
+		errs = append(errs, fmt.Errorf("retries must be between 0 and 10, got %d", p.Retries))
+	}
+	if p.BackoffMs < 0 {
+		errs = append(errs, fmt.Errorf("backoff_ms must not be negative, got %d", p.BackoffMs))
+	}
+	if p.TimeoutMs < 0 {
+		errs = append(errs, fmt.Errorf("timeout_ms must not be negative, got %d", p.TimeoutMs))
+	}
+	return errors.Join(errs...)
+}
+
+// DefaultPolicyOf 返回 Pass 自身声明的默认策略，未声明时为 fail-fast。
+func DefaultPolicyOf(pass Pass) Policy {
+	if pp, ok := pass.(PolicyProvider); ok {
+		return pp.DefaultPolicy()
+	}
+	return Policy{OnError: OnErrorFail}
+}
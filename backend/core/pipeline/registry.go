@@ -0,0 +1,185 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"context-fabric/backend/core/domain"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HistoryStore 是 Pass 读取会话历史与保存滚动摘要所需的最小接口。
+type HistoryStore interface {
+	Get(ctx context.Context, id string) (*domain.Session, error)
+	SaveSummary(ctx context.Context, id string, summary *domain.RollingSummary) error
+}
+
+// MemoryStore 是 Pass 访问长期记忆系统所需的最小接口。
+type MemoryStore interface {
+	GetEmbedding(ctx context.Context, text string, modelID string) ([]float32, error)
+	Retrieve(ctx context.Context, vector []float32, limit int, withVector bool) ([]domain.SharedMemory, []domain.StagingFact, error)
+	Ingest(ctx context.Context, sessionID string, messages []domain.Message, modelID string, sanitizationModel string) error
+}
+
+// PromptStore 是 SystemPromptPass 渲染系统提示词模板所需的最小接口，返回渲染结果与模板版本号。
+type PromptStore interface {
+	Render(ctx context.Context, appID string, vars domain.PromptVars) (string, int, error)
+}
+
+// DocumentIndex 是 RAGPass 做关键词（BM25）检索所需的最小接口。
+type DocumentIndex interface {
+	Search(collection, query string, limit int) []domain.RetrievedChunk
+}
+
+// Reranker 以交叉编码器为查询与候选文本打分，返回与 docs 一一对应的相关性得分。
+type Reranker interface {
+	Rerank(ctx context.Context, model, query string, docs []string) ([]float64, error)
+}
+
+// Dependencies 汇集了 Pass 构造时可能用到的外部服务，由引擎在启动时统一注入。
+type Dependencies struct {
+	LLMServiceURL string
+	History       HistoryStore
+	Memory        MemoryStore
+	Prompts       PromptStore
+	Documents     DocumentIndex
+	Reranker      Reranker
+}
+
+// ConfigValidator 可由 Pass 参数结构体实现，在构造 Pass 之前校验参数取值。
+type ConfigValidator interface {
+	Validate() error
+}
+
+// ParamSchema 描述 Pass 的一个可配置参数，用于管理端展示。
+type ParamSchema struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Default interface{} `json:"default"`
+}
+
+// PassDefinition 描述一个可以按名称构造的 Pass。
+type PassDefinition struct {
+	Name        string
+	Description string
+	// newConfig 返回填充了默认值的参数结构体指针，既是类型化的 Schema，也是解析目标。
+	newConfig func() interface{}
+	// factory 基于解析、校验后的参数构造 Pass 实例。
+	factory func(cfg interface{}, deps Dependencies) (Pass, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]PassDefinition)
+)
+
+// RegisterPass 以类型化的参数结构注册一个 Pass。
+// defaults 为参数默认值，配置中未出现的字段沿用该值；重复注册同名 Pass 会触发 panic。
+func RegisterPass[C any](name, description string, defaults C, factory func(cfg C, deps Dependencies) (Pass, error)) {
+	if name == "" {
+		panic("pipeline: RegisterPass called with empty name")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("pipeline: pass %q registered twice", name))
+	}
+	registry[name] = PassDefinition{
+		Name:        name,
+		Description: description,
+		newConfig: func() interface{} {
+			cfg := defaults
+			return &cfg
+		},
+		factory: func(cfg interface{}, deps Dependencies) (Pass, error) {
+			return factory(*cfg.(*C), deps)
+		},
+	}
+}
+
+// Lookup 按名称查找已注册的 Pass 定义。
+func Lookup(name string) (PassDefinition, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	def, ok := registry[name]
+	return def, ok
+}
+
+// Definitions 按名称排序返回所有已注册的 Pass 定义。
+func Definitions() []PassDefinition {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	defs := make([]PassDefinition, 0, len(registry))
+	for _, def := range registry {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// ParseConfig 将原始 JSON 参数严格解析为该 Pass 的参数结构并完成校验。
+func (d PassDefinition) ParseConfig(raw json.RawMessage) (interface{}, error) {
+	cfg := d.newConfig()
+	if len(raw) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("invalid params: %w", err)
+		}
+	}
+	if v, ok := cfg.(ConfigValidator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// Schema 通过反射参数结构体的 json 标签，列出该 Pass 支持的参数及默认值。
+func (d PassDefinition) Schema() []ParamSchema {
+	cfg := reflect.ValueOf(d.newConfig()).Elem()
+	if cfg.Kind() != reflect.Struct {
+		return nil
+	}
+
+	params := make([]ParamSchema, 0, cfg.NumField())
+	for i := 0; i < cfg.NumField(); i++ {
+		field := cfg.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		params = append(params, ParamSchema{
+			Name:    name,
+			Type:    field.Type.String(),
+			Default: cfg.Field(i).Interface(),
+		})
+	}
+	return params
+}
+
+// Build 解析参数并实例化该 Pass。
+func (d PassDefinition) Build(raw json.RawMessage, deps Dependencies) (Pass, error) {
+	cfg, err := d.ParseConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	return d.factory(cfg, deps)
+}
+
+// BuildPass 按名称查找并实例化一个 Pass。
+func BuildPass(name string, raw json.RawMessage, deps Dependencies) (Pass, error) {
+	def, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown pass %q", name)
+	}
+	return def.Build(raw, deps)
+}
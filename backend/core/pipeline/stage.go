@@ -0,0 +1,117 @@
+package pipeline
+
+import (
+	"context"
+	"context-fabric/backend/core/domain"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GuardProvider 可由 Pass 实现，用于声明其默认的执行守卫条件（如 "rag_enabled"）。
+// 配置文件中出现 when 字段时将整体替换默认条件。
+type GuardProvider interface {
+	DefaultWhen() []string
+}
+
+// DefaultWhenOf 返回 Pass 自身声明的默认守卫条件。
+func DefaultWhenOf(pass Pass) []string {
+	if gp, ok := pass.(GuardProvider); ok {
+		return gp.DefaultWhen()
+	}
+	return nil
+}
+
+// Stage 是管线中的一个执行单元：Pass 及其运行策略与守卫条件。
+type Stage struct {
+	Pass   Pass
+	Policy Policy
+	// When 中的条件全部满足时才执行该 Pass，否则以 Skipped 记录并跳过。
+	When []Condition
+}
+
+// NewStage 使用 Pass 声明的默认策略与守卫条件创建执行单元。
+func NewStage(pass Pass) (Stage, error) {
+	when, err := ParseConditions(DefaultWhenOf(pass))
+	if err != nil {
+		return Stage{}, fmt.Errorf("pass %s: %w", pass.Name(), err)
+	}
+	return Stage{Pass: pass, Policy: DefaultPolicyOf(pass), When: when}, nil
+}
+
+// stageResult 汇总一次 Stage 执行的结果，用于生成 Complete Trace。
+type stageResult struct {
+	outcome  string
+	attempts int
+	err      error
+}
+
+// run 按策略执行 Pass：单次执行受 TimeoutMs 约束，失败时回滚消息列表与 Meta 并按指数退避重试。
+// 仅当最终失败且策略为 fail 时返回 error。
+func (s Stage) run(ctx context.Context, data *ContextData) (stageResult, error) {
+	var res stageResult
+	backoff := time.Duration(s.Policy.BackoffMs) * time.Millisecond
+	if backoff == 0 {
+		backoff = defaultBackoff
+	}
+
+	for attempt := 0; attempt <= s.Policy.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				res.err = ctx.Err()
+			case <-time.After(backoff):
+				backoff *= 2
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		res.attempts++
+		snapshot := append([]domain.Message(nil), data.Messages...)
+		metaSnapshot := copyMeta(data.Meta)
+		res.err = s.runOnce(ctx, data)
+		if res.err == nil {
+			res.outcome = OutcomeOK
+			if attempt > 0 {
+				res.outcome = OutcomeRetried
+			}
+			return res, nil
+		}
+
+		// 丢弃失败尝试对消息列表与 Meta 的部分修改；Meta 原地恢复，保持与其他引用共享同一个 map
+		data.Messages = snapshot
+		clear(data.Meta)
+		for k, v := range metaSnapshot {
+			data.Meta[k] = v
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	timedOut := errors.Is(res.err, context.DeadlineExceeded)
+	if s.Policy.OnError == OnErrorSkip {
+		res.outcome = OutcomeSkipped
+		if timedOut {
+			res.outcome = OutcomeTimeout
+		}
+		return res, nil
+	}
+
+	res.outcome = OutcomeFailed
+	if timedOut {
+		res.outcome = OutcomeTimeout
+	}
+	return res, res.err
+}
+
+func (s Stage) runOnce(ctx context.Context, data *ContextData) error {
+	if s.Policy.TimeoutMs > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(s.Policy.TimeoutMs)*time.Millisecond)
+		defer cancel()
+	}
+	return s.Pass.Run(ctx, data)
+}
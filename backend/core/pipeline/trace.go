@@ -0,0 +1,181 @@
+package pipeline
+
+import (
+	"context-fabric/backend/core/domain"
+	"fmt"
+	"time"
+)
+
+// SpanStatus 表示 Span 的结束状态，转换为 domain.TraceEvent 时作为 Action。
+type SpanStatus string
+
+const (
+	SpanRunning  SpanStatus = "Running"
+	SpanComplete SpanStatus = "Complete" // Pass 已执行（无论成功、跳过或失败，详见 outcome 属性）
+	SpanSkipped  SpanStatus = "Skipped"  // Pass 因守卫条件未满足而未执行
+)
+
+// Attrs 是 Span 或 Event 上附带的键值属性。
+type Attrs map[string]interface{}
+
+// Event 是 Span 内部发生的一次业务事件（如 Summarizer 的 Summarized、TokenLimitPass 的 Truncate）。
+type Event struct {
+	Source string    `json:"source"` // 产生事件的组件，通常为当前 Pass 名称
+	Target string    `json:"target"` // 事件作用的对象，如 Qdrant、LLMService
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+	Attrs  Attrs     `json:"attrs,omitempty"`
+}
+
+// Span 记录一次执行区间：根 Span 对应整条管线，子 Span 对应单个 Pass。
+type Span struct {
+	ID       string     `json:"id"`
+	ParentID string     `json:"parent_id,omitempty"`
+	Name     string     `json:"name"`
+	Status   SpanStatus `json:"status"`
+	Start    time.Time  `json:"start"`
+	End      time.Time  `json:"end"`
+	Attrs    Attrs      `json:"attrs,omitempty"`
+	Events   []Event    `json:"events,omitempty"`
+}
+
+// SetAttr 设置 Span 属性。
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s.Attrs == nil {
+		s.Attrs = make(Attrs)
+	}
+	s.Attrs[key] = value
+}
+
+// AddEvent 在 Span 内记录一条事件。
+func (s *Span) AddEvent(source, target, action string, attrs Attrs) {
+	s.Events = append(s.Events, Event{
+		Source: source,
+		Target: target,
+		Action: action,
+		Time:   time.Now(),
+		Attrs:  attrs,
+	})
+}
+
+// Duration 返回 Span 的执行耗时，未结束时返回 0。
+func (s *Span) Duration() time.Duration {
+	if s.End.IsZero() {
+		return 0
+	}
+	return s.End.Sub(s.Start)
+}
+
+// Trace 收集一次管线运行的全部 Span。
+// Pass Span 按管线中的确定性顺序记录（并发组按声明顺序），与消息合并顺序保持一致。
+type Trace struct {
+	Root   *Span   `json:"root"`
+	Spans  []*Span `json:"spans"`
+	nextID int
+
+	// snapshot 最近一个 Pass 结束时的消息快照，作为下一个 Pass 差异的基线。
+	snapshot []SnapshotMessage
+}
+
+// NewTrace 创建以 name 为根 Span 的踪迹。
+func NewTrace(name string) *Trace {
+	t := &Trace{}
+	t.Root = t.newSpan(name, "")
+	return t
+}
+
+func (t *Trace) newSpan(name, parentID string) *Span {
+	t.nextID++
+	return &Span{
+		ID:       fmt.Sprintf("span-%d", t.nextID),
+		ParentID: parentID,
+		Name:     name,
+		Status:   SpanRunning,
+		Start:    time.Now(),
+		Attrs:    make(Attrs),
+	}
+}
+
+// StartSpan 创建根 Span 下的子 Span。Span 只有在调用 Finish 后才会被记录。
+func (t *Trace) StartSpan(name string) *Span {
+	return t.newSpan(name, t.Root.ID)
+}
+
+// RecordMessages 计算当前消息列表相对上一次记录的差异，并更新基线。
+func (t *Trace) RecordMessages(msgs []domain.Message) MessageDiff {
+	next := snapshotOf(msgs)
+	diff := DiffMessages(t.snapshot, next)
+	t.snapshot = next
+	return diff
+}
+
+// Finish 结束 Span 并按调用顺序记录到 Trace 中。
+func (t *Trace) Finish(span *Span, status SpanStatus) {
+	span.Status = status
+	if span.End.IsZero() {
+		span.End = time.Now()
+	}
+	if span == t.Root {
+		return
+	}
+	t.Spans = append(t.Spans, span)
+}
+
+// DomainEvents 将 Pass Span 转换为观测仪使用的 domain.TraceEvent。
+// 每个 Pass Span 折叠为一个节点：Action 为 Span 状态，Data 为 Span 属性，
+// 其内部事件以 internal_logs 的形式附着（每条带 internal_action 与 internal_component）。
+// 根 Span 的 Start/Finished 信息不输出，避免时序图过度拥挤。
+func (t *Trace) DomainEvents() []domain.TraceEvent {
+	events := make([]domain.TraceEvent, 0, len(t.Spans))
+	var last time.Time
+	for _, span := range t.Spans {
+		data := make(map[string]interface{}, len(span.Attrs)+1)
+		for k, v := range span.Attrs {
+			data[k] = v
+		}
+		if len(span.Events) > 0 {
+			logs := make([]map[string]interface{}, 0, len(span.Events))
+			for _, ev := range span.Events {
+				entry := make(map[string]interface{}, len(ev.Attrs)+2)
+				for k, v := range ev.Attrs {
+					entry[k] = v
+				}
+				entry["internal_action"] = ev.Action
+				entry["internal_component"] = ev.Source
+				logs = append(logs, entry)
+			}
+			data["internal_logs"] = logs
+		}
+
+		// 前端按时间戳排序，保证并发组内的节点仍按声明顺序严格递增
+		ts := span.End
+		if !ts.After(last) {
+			ts = last.Add(time.Microsecond)
+		}
+		last = ts
+
+		events = append(events, domain.TraceEvent{
+			Source:    "Core",
+			Target:    "Core",
+			Action:    string(span.Status),
+			Data:      data,
+			Timestamp: ts,
+		})
+	}
+	return events
+}
+
+// Event 在当前 Pass 的 Span 中记录一条内部事件，事件来源为当前 Pass 名称。
+// 在管线之外单独运行 Pass 时没有当前 Span，事件会被忽略。
+func (d *ContextData) Event(target, action string, attrs Attrs) {
+	if d.span != nil {
+		d.span.AddEvent(d.span.Name, target, action, attrs)
+	}
+}
+
+// SetAttr 设置当前 Pass Span 的属性，最终出现在该 Pass 的 Trace 节点数据中。
+func (d *ContextData) SetAttr(key string, value interface{}) {
+	if d.span != nil {
+		d.span.SetAttr(key, value)
+	}
+}
@@ -13,13 +13,28 @@ type ContextData struct {
 	// Messages 当前管线中正在处理的消息列表
 	Messages []domain.Message
 
+	// Pending 尚未持久化的消息（如 dry-run 时的用户提问），由 HistoryLoader 追加在历史消息之后。
+	Pending []domain.Message
+
+	// DryRun 为 true 时（如 explain），Pass 不应写入任何持久化状态。
+	DryRun bool
+
 	// Meta 用于在不同 Pass 之间传递临时或统计数据。
 	// 例如：Token 计数结果、检索到的知识片段等。
 	Meta map[string]interface{}
 
-	// Traces 收集 Pipeline 执行过程中的关键路径信息。
-	// 这些信息最终会被归一化并展示在前端的交互观测仪中。
-	Traces []map[string]interface{}
+	// Trace 收集 Pipeline 执行过程中的 Span 与事件。
+	// Pass 通过 Event/SetAttr 写入当前 Span，最终由 DomainEvents 转换并展示在前端的交互观测仪中。
+	Trace *Trace
+
+	// Embedder 用于计算查询向量，通过 Embedding 方法访问以复用本次运行内的缓存。
+	Embedder Embedder
+
+	// embeddings 本次运行内的向量缓存，并发分支之间共享。
+	embeddings *embeddingCache
+
+	// span 当前正在执行的 Pass 对应的 Span。
+	span *Span
 }
 
 // Pass 定义了上下文处理的单一职责单元（插件化设计）。
@@ -33,6 +48,6 @@ type Pass interface {
 	Description() string
 
 	// Run 执行该处理单元的具体业务逻辑。
-	// 如果返回 error，Pipeline 的执行流程将被中断。
+	// 返回 error 时由该 Pass 的 Policy 决定重试、跳过或中断整个 Pipeline。
 	Run(ctx context.Context, data *ContextData) error
 }
@@ -0,0 +1,267 @@
+package prompts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// 内置语言。
+const (
+	LocaleZH = "zh"
+	LocaleEN = "en"
+	LocaleJA = "ja"
+
+	DefaultLocale = LocaleZH
+)
+
+// 注入模板名称。
+const (
+	InjectRAG                  = "rag"                   // RAGPass 注入的参考信息，数据 RAGData
+	InjectMemory               = "memory"                // Constitution 注入的记忆与事实，数据 MemoryData
+	InjectSummary              = "summary"               // 摘要消息，数据 SummaryData
+	InjectSummaryBody          = "summary_body"          // 会话级摘要与分段摘要的正文，数据 SummaryBodyData
+	InjectSummarize            = "summarize"             // 首次摘要指令，数据 SummarizeData
+	InjectSummarizeIncremental = "summarize_incremental" // 增量摘要指令，数据 SummarizeData
+	InjectSummarizeRollup      = "summarize_rollup"      // 分段汇总指令，数据 RollupData
+	InjectQueryRewrite         = "query_rewrite"         // 检索查询改写指令，数据 QueryRewriteData
+	InjectElided               = "elided"                // 消息内截断省略正文的标记，数据 ElidedData
+	InjectElidedCode           = "elided_code"           // 消息内截断省略代码块的标记，数据 ElidedData
+)
+
+var injectNames = []string{InjectRAG, InjectMemory, InjectSummary, InjectSummaryBody, InjectSummarize, InjectSummarizeIncremental, InjectSummarizeRollup, InjectQueryRewrite, InjectElided, InjectElidedCode}
+
+// RAGData 是 rag 模板的数据。Snippets 与 Sources 内容相同，保留前者以兼容不带引用编号的自定义模板。
+type RAGData struct {
+	Snippets []string
+	Sources  []RAGSource
+}
+
+// RAGSource 是带引用编号的一条参考片段，Index 从 1 开始，与回复中的 [n] 标记对应。
+type RAGSource struct {
+	Index   int
+	Title   string
+	Content string
+}
+
+// MemoryData 是 memory 模板的数据。
+type MemoryData struct {
+	Memories []string // 长期记忆
+	Facts    []string // 近期事实
+}
+
+// SummaryData 是 summary 模板的数据。
+type SummaryData struct {
+	Body string
+}
+
+// SummaryBodyData 是 summary_body 模板的数据。
+type SummaryBodyData struct {
+	Content string // 会话级摘要
+	Chunks  []SummaryChunkData
+}
+
+// SummaryChunkData 是一段分段摘要，From / To 为从 1 开始的消息序号（含两端）。
+type SummaryChunkData struct {
+	From, To int
+	Content  string
+}
+
+// SummarizeData 是 summarize 与 summarize_incremental 模板的数据。
+type SummarizeData struct {
+	Previous string // 上一版摘要，仅增量模式
+	History  string // 序列化的对话历史
+}
+
+// QueryRewriteData 是 query_rewrite 模板的数据。
+type QueryRewriteData struct {
+	History string // 序列化的最近对话
+	Query   string // 用户最新提问
+}
+
+// ElidedData 是 elided 与 elided_code 模板的数据。
+type ElidedData struct {
+	Tokens int // 省略的 Token 数，仅 elided
+	Lines  int // 省略的代码行数，仅 elided_code
+}
+
+// RollupData 是 summarize_rollup 模板的数据。
+type RollupData struct {
+	Summary string // 已有的会话级摘要，可能为空
+	Chunks  string // 待汇总的分段摘要列表
+}
+
+const memoryLists = `{{if .Memories}}%s
+{{range .Memories}}- {{.}}
+{{end}}{{end}}{{if and .Memories .Facts}}
+{{end}}{{if .Facts}}%s
+{{range .Facts}}- {{.}}
+{{end}}{{end}}`
+
+const summaryBody = `{{if .Chunks}}{{if .Content}}{{.Content}}
+
+{{end}}{{range .Chunks}}- (%s {{.From}}-{{.To}}) {{.Content}}
+{{end}}{{else}}{{.Content}}{{end}}`
+
+// builtinLocales 是内置的注入模板。
+var builtinLocales = map[string]map[string]string{
+	LocaleZH: {
+		InjectRAG: "以下是检索到的参考信息，请结合这些信息回答用户问题，引用某条信息时在相应句末标注其编号（如 [1]）：\n\n{{range .Sources}}[{{.Index}}]{{if .Title}} {{.Title}}{{end}}\n{{.Content}}\n\n{{end}}",
+		InjectMemory: "这是从你的长期记忆和近期交互中提取的背景信息，请在回复时参考：\n\n" +
+			fmt.Sprintf(memoryLists, "### 核心事实与偏好 (长期)", "### 相关近期事件 (暂存)"),
+		InjectSummary:              "[历史会话摘要]:\n{{.Body}}",
+		InjectSummaryBody:          fmt.Sprintf(summaryBody, "消息"),
+		InjectSummarize:            "请简要总结以下对话历史，提取核心事实、用户偏好和重要决策。要求：简洁、客观，不超过 200 字。\n\n对话历史：\n{{.History}}",
+		InjectSummarizeIncremental: "以下是此前对话的摘要以及之后的新对话。请将新对话中的核心事实、用户偏好和重要决策合并进摘要，输出更新后的完整摘要。要求：简洁、客观，不超过 200 字。\n\n已有摘要：\n{{.Previous}}\n\n新对话：\n{{.History}}",
+		InjectSummarizeRollup:      "以下是一段长对话的整体摘要，以及之后若干阶段的分段摘要。请将分段摘要合并进整体摘要，保留核心事实、用户偏好和重要决策，输出更新后的整体摘要。要求：简洁、客观，不超过 300 字。\n\n整体摘要：\n{{if .Summary}}{{.Summary}}{{else}}（无）{{end}}\n\n分段摘要：\n{{.Chunks}}",
+		InjectQueryRewrite:         "请结合以下对话，将用户的最新提问改写为一条无需上下文即可理解的独立检索查询：补全代词与省略指代的对象，保留专有名词、标识符与原语言。只输出改写后的查询，不要回答问题。\n\n对话：\n{{.History}}\n最新提问：{{.Query}}",
+		InjectElided:               "\n…[已省略 {{.Tokens}} 个 Token]…\n",
+		InjectElidedCode:           "\n…[已省略代码块 {{.Lines}} 行]…\n",
+	},
+	LocaleEN: {
+		InjectRAG: "The following reference information was retrieved. Use it to answer the user's question, and when you rely on an item, cite its number at the end of the sentence (e.g. [1]):\n\n{{range .Sources}}[{{.Index}}]{{if .Title}} {{.Title}}{{end}}\n{{.Content}}\n\n{{end}}",
+		InjectMemory: "Background information extracted from your long-term memory and recent interactions. Refer to it when replying:\n\n" +
+			fmt.Sprintf(memoryLists, "### Core facts and preferences (long-term)", "### Related recent events (staging)"),
+		InjectSummary:              "[Conversation summary]:\n{{.Body}}",
+		InjectSummaryBody:          fmt.Sprintf(summaryBody, "messages"),
+		InjectSummarize:            "Briefly summarize the following conversation history, extracting key facts, user preferences and important decisions. Be concise and objective, in no more than 150 words.\n\nConversation history:\n{{.History}}",
+		InjectSummarizeIncremental: "Below is a summary of the earlier conversation followed by newer messages. Merge the key facts, user preferences and important decisions from the newer messages into the summary and output the complete updated summary. Be concise and objective, in no more than 150 words.\n\nExisting summary:\n{{.Previous}}\n\nNew messages:\n{{.History}}",
+		InjectSummarizeRollup:      "Below is the overall summary of a long conversation followed by summaries of several later segments. Merge the segment summaries into the overall summary, keeping key facts, user preferences and important decisions, and output the updated overall summary. Be concise and objective, in no more than 200 words.\n\nOverall summary:\n{{if .Summary}}{{.Summary}}{{else}}(none){{end}}\n\nSegment summaries:\n{{.Chunks}}",
+		InjectQueryRewrite:         "Using the conversation below, rewrite the user's latest question as a standalone search query that can be understood without the conversation: resolve pronouns and elided references, and keep proper nouns, identifiers and the original language. Output only the rewritten query and do not answer it.\n\nConversation:\n{{.History}}\nLatest question: {{.Query}}",
+		InjectElided:               "\n…[{{.Tokens}} tokens omitted]…\n",
+		InjectElidedCode:           "\n…[code block of {{.Lines}} lines omitted]…\n",
+	},
+	LocaleJA: {
+		InjectRAG: "以下は検索された参考情報です。これらを踏まえてユーザーの質問に回答し、情報を引用する際は該当する文末にその番号（例：[1]）を付けてください：\n\n{{range .Sources}}[{{.Index}}]{{if .Title}} {{.Title}}{{end}}\n{{.Content}}\n\n{{end}}",
+		InjectMemory: "以下は長期記憶と最近のやり取りから抽出した背景情報です。回答の際に参考にしてください：\n\n" +
+			fmt.Sprintf(memoryLists, "### 重要な事実と好み（長期）", "### 関連する最近の出来事（一時保存）"),
+		InjectSummary:              "[会話履歴の要約]:\n{{.Body}}",
+		InjectSummaryBody:          fmt.Sprintf(summaryBody, "メッセージ"),
+		InjectSummarize:            "以下の会話履歴を簡潔に要約し、重要な事実、ユーザーの好み、重要な決定を抽出してください。簡潔かつ客観的に、300 文字以内でまとめてください。\n\n会話履歴：\n{{.History}}",
+		InjectSummarizeIncremental: "以下はこれまでの会話の要約と、その後の新しい会話です。新しい会話に含まれる重要な事実、ユーザーの好み、重要な決定を要約に統合し、更新後の要約全体を出力してください。簡潔かつ客観的に、300 文字以内でまとめてください。\n\n既存の要約：\n{{.Previous}}\n\n新しい会話：\n{{.History}}",
+		InjectSummarizeRollup:      "以下は長い会話全体の要約と、その後のいくつかの段階ごとの要約です。段階ごとの要約を全体の要約に統合し、重要な事実、ユーザーの好み、重要な決定を残した更新後の全体要約を出力してください。簡潔かつ客観的に、400 文字以内でまとめてください。\n\n全体の要約：\n{{if .Summary}}{{.Summary}}{{else}}（なし）{{end}}\n\n段階ごとの要約：\n{{.Chunks}}",
+		InjectQueryRewrite:         "以下の会話を踏まえ、ユーザーの最新の質問を、会話がなくても理解できる独立した検索クエリに書き換えてください。代名詞や省略された対象を補い、固有名詞・識別子・元の言語はそのまま残してください。書き換えたクエリのみを出力し、質問には回答しないでください。\n\n会話：\n{{.History}}\n最新の質問：{{.Query}}",
+		InjectElided:               "\n…[{{.Tokens}} トークン省略]…\n",
+		InjectElidedCode:           "\n…[コードブロック {{.Lines}} 行省略]…\n",
+	},
+}
+
+var (
+	localeMu sync.RWMutex
+	locales  = mustParseLocales(builtinLocales)
+)
+
+func mustParseLocales(src map[string]map[string]string) map[string]map[string]*template.Template {
+	out, err := parseLocales(src)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func parseLocales(src map[string]map[string]string) (map[string]map[string]*template.Template, error) {
+	var errs []error
+	out := make(map[string]map[string]*template.Template, len(src))
+	for _, locale := range sortedLocaleKeys(src) {
+		out[locale] = make(map[string]*template.Template, len(src[locale]))
+		for _, name := range sortedLocaleKeys(src[locale]) {
+			if !knownInjection(name) {
+				errs = append(errs, fmt.Errorf("%s.%s: unknown template (known: %v)", locale, name, injectNames))
+				continue
+			}
+			tmpl, err := template.New(locale + "." + name).Parse(src[locale][name])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.%s: %w", locale, name, err))
+				continue
+			}
+			out[locale][name] = tmpl
+		}
+	}
+	return out, errors.Join(errs...)
+}
+
+func knownInjection(name string) bool {
+	for _, n := range injectNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedLocaleKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LoadLocales 从 JSON 文件（语言 -> 模板名 -> 模板）加载注入模板，覆盖同名的内置模板；
+// 可以新增语言，未提供的模板回退到默认语言。
+func LoadLocales(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read locale templates: %w", err)
+	}
+	var src map[string]map[string]string
+	if err := json.Unmarshal(raw, &src); err != nil {
+		return fmt.Errorf("parse locale templates: %w", err)
+	}
+	parsed, err := parseLocales(src)
+	if err != nil {
+		return err
+	}
+	localeMu.Lock()
+	defer localeMu.Unlock()
+	for locale, tmpls := range parsed {
+		if locales[locale] == nil {
+			locales[locale] = make(map[string]*template.Template, len(tmpls))
+		}
+		for name, tmpl := range tmpls {
+			locales[locale][name] = tmpl
+		}
+	}
+	return nil
+}
+
+// NormalizeLocale 将 en-US、ja_JP 等写法归一为已配置的语言，未配置时返回空串。
+func NormalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	localeMu.RLock()
+	defer localeMu.RUnlock()
+	if _, ok := locales[locale]; ok {
+		return locale
+	}
+	if base, _, ok := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-"); ok {
+		if _, ok := locales[base]; ok {
+			return base
+		}
+	}
+	return ""
+}
+
+// Inject 按语言渲染注入模板；语言未配置或缺少该模板时回退到默认语言。
+func Inject(locale, name string, data interface{}) (string, error) {
+	localeMu.RLock()
+	tmpl := locales[locale][name]
+	if tmpl == nil {
+		tmpl = locales[DefaultLocale][name]
+	}
+	localeMu.RUnlock()
+	if tmpl == nil {
+		return "", fmt.Errorf("injection template %q not found", name)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render %s template for locale %q: %w", name, locale, err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,202 @@
+// Package prompts 管理按 AppID 配置的系统提示词模板（Go text/template），版本只追加不修改以便审计。
+package prompts
+
+import (
+	"bytes"
+	"context"
+	"context-fabric/backend/core/domain"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Builtin 是没有任何模板配置时使用的内置系统提示词。
+const Builtin = "你是一个由 ContextFabric 驱动的智能助手。当前系统时间: {{.Time}}"
+
+// Repository 定义了提示词模板的持久化接口。
+type Repository interface {
+	Save(ctx context.Context, t *domain.PromptTemplate) error
+	Get(ctx context.Context, appID string) (*domain.PromptTemplate, error)
+	List(ctx context.Context) ([]domain.PromptTemplateSummary, error)
+	Delete(ctx context.Context, appID string) error
+}
+
+var appIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Service 负责模板的发布、回滚与渲染，已解析的模板按 AppID 与版本缓存。
+type Service struct {
+	repo  Repository
+	mu    sync.Mutex // 串行化发布与回滚的读改写
+	cache sync.Map   // "appID@version" -> *template.Template
+}
+
+func NewService(r Repository) *Service {
+	return &Service{repo: r}
+}
+
+// Parse 解析模板内容；缺失的 User 字段渲染为空串。
+func Parse(name, content string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Parse(content)
+}
+
+// Execute 使用变量渲染已解析的模板。
+func Execute(tmpl *template.Template, vars domain.PromptVars) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// SampleVars 返回用于校验与预览模板的示例变量。
+func SampleVars() domain.PromptVars {
+	now := time.Now()
+	return domain.PromptVars{
+		Now:         now,
+		Time:        now.Format("15:04:05"),
+		Date:        now.Format("2006-01-02"),
+		Timezone:    now.Location().String(),
+		AppID:       "preview",
+		SessionID:   "session-preview",
+		SessionName: "预览会话",
+		ModelID:     "deepseek-chat",
+		User:        map[string]string{"name": "张三", "language": "zh"},
+		MemoryCount: 3,
+		FactCount:   2,
+	}
+}
+
+// Validate 校验模板能否解析并以示例变量渲染。
+func Validate(content string) error {
+	tmpl, err := Parse("validate", content)
+	if err != nil {
+		return fmt.Errorf("parse template: %w", err)
+	}
+	if _, err := Execute(tmpl, SampleVars()); err != nil {
+		return fmt.Errorf("execute template: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) List(ctx context.Context) ([]domain.PromptTemplateSummary, error) {
+	return s.repo.List(ctx)
+}
+
+func (s *Service) Get(ctx context.Context, appID string) (*domain.PromptTemplate, error) {
+	return s.repo.Get(ctx, appID)
+}
+
+// Delete 删除 AppID 的全部版本并清除其解析缓存；重新发布后版本号从 1 开始，不能再命中旧缓存。
+func (s *Service) Delete(ctx context.Context, appID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.repo.Delete(ctx, appID); err != nil {
+		return err
+	}
+	prefix := appID + "@"
+	s.cache.Range(func(key, _ any) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			s.cache.Delete(key)
+		}
+		return true
+	})
+	return nil
+}
+
+// Publish 校验并发布新版本，新版本立即生效。
+func (s *Service) Publish(ctx context.Context, appID, content, author, comment string) (*domain.PromptVersion, error) {
+	if !appIDPattern.MatchString(appID) {
+		return nil, fmt.Errorf("invalid app_id %q", appID)
+	}
+	if err := Validate(content); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.repo.Get(ctx, appID)
+	if errors.Is(err, fs.ErrNotExist) {
+		t, err = &domain.PromptTemplate{AppID: appID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v := domain.PromptVersion{
+		Version:   len(t.Versions) + 1,
+		Content:   content,
+		Author:    author,
+		Comment:   comment,
+		CreatedAt: time.Now(),
+	}
+	t.Versions = append(t.Versions, v)
+	t.UpdatedAt = v.CreatedAt
+	if err := s.repo.Save(ctx, t); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Rollback 以指定历史版本的内容发布一个新版本，保证版本记录只追加。
+func (s *Service) Rollback(ctx context.Context, appID string, version int, author string) (*domain.PromptVersion, error) {
+	t, err := s.repo.Get(ctx, appID)
+	if err != nil {
+		return nil, err
+	}
+	if version <= 0 || version > len(t.Versions) {
+		return nil, fmt.Errorf("version %d not found (have 1-%d)", version, len(t.Versions))
+	}
+	return s.Publish(ctx, appID, t.Versions[version-1].Content, author, fmt.Sprintf("rollback to v%d", version))
+}
+
+// Render 渲染 AppID 当前生效的模板：未配置时依次回退到 default 模板与内置提示词（版本号为 0）。
+func (s *Service) Render(ctx context.Context, appID string, vars domain.PromptVars) (string, int, error) {
+	for _, id := range []string{appID, domain.DefaultPromptApp} {
+		if id == "" {
+			continue
+		}
+		t, err := s.repo.Get(ctx, id)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		cur := t.Current()
+		if cur == nil {
+			continue
+		}
+		tmpl, err := s.parsed(id, cur)
+		if err != nil {
+			return "", 0, err
+		}
+		out, err := Execute(tmpl, vars)
+		return out, cur.Version, err
+	}
+	out, err := RenderBuiltin(vars)
+	return out, 0, err
+}
+
+// RenderBuiltin 渲染内置提示词。
+func RenderBuiltin(vars domain.PromptVars) (string, error) {
+	return Execute(builtin, vars)
+}
+
+var builtin = template.Must(Parse("builtin", Builtin))
+
+func (s *Service) parsed(appID string, v *domain.PromptVersion) (*template.Template, error) {
+	key := fmt.Sprintf("%s@%d", appID, v.Version)
+	if tmpl, ok := s.cache.Load(key); ok {
+		return tmpl.(*template.Template), nil
+	}
+	tmpl, err := Parse(key, v.Content)
+	if err != nil {
+		return nil, fmt.Errorf("parse template %s: %w", key, err)
+	}
+	s.cache.Store(key, tmpl)
+	return tmpl, nil
+}
@@ -0,0 +1,45 @@
+package tokens
+
+import "context-fabric/backend/core/domain"
+
+// MessageCount 是单条消息的计数明细。
+type MessageCount struct {
+	Index    int    `json:"index"`
+	Role     string `json:"role"`
+	Content  int    `json:"content"`  // 消息正文的 Token 数
+	Overhead int    `json:"overhead"` // 角色与分隔符开销
+	Total    int    `json:"total"`
+}
+
+// Breakdown 是消息列表在某个模型下的计数结果，与 TokenLimitPass 的口径一致。
+type Breakdown struct {
+	ModelID       string         `json:"model_id"`
+	Pattern       string         `json:"pattern"` // 命中的目录规则，默认规格为空
+	Encoding      string         `json:"encoding"`
+	Exact         bool           `json:"exact"` // false 表示分词器不可用或模型无公开分词器，结果为估算
+	ContextWindow int            `json:"context_window"`
+	MaxOutput     int            `json:"max_output"`
+	Messages      []MessageCount `json:"messages"`
+	Total         int            `json:"total"`
+}
+
+// CountBreakdown 按模型目录选择计数器，返回每条消息与总计的 Token 数。
+func CountBreakdown(modelID string, msgs []domain.Message) Breakdown {
+	c := ForModel(modelID)
+	out := Breakdown{
+		ModelID:       modelID,
+		Pattern:       c.Spec.Pattern,
+		Encoding:      c.Spec.Encoding,
+		Exact:         c.Exact(),
+		ContextWindow: c.Spec.ContextWindow,
+		MaxOutput:     c.Spec.MaxOutput,
+		Messages:      make([]MessageCount, 0, len(msgs)),
+	}
+	for i, m := range msgs {
+		n := c.Count(m.Content)
+		mc := MessageCount{Index: i, Role: m.Role, Content: n, Overhead: c.Spec.MessageOverhead, Total: n + c.Spec.MessageOverhead}
+		out.Messages = append(out.Messages, mc)
+		out.Total += mc.Total
+	}
+	return out
+}
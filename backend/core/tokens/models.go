@@ -0,0 +1,158 @@
+package tokens
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 分词器编码名称。EncodingEstimate 表示没有公开分词器，按字符估算。
+const (
+	EncodingCL100K   = "cl100k_base"
+	EncodingO200K    = "o200k_base"
+	EncodingEstimate = "estimate"
+)
+
+// ModelSpec 描述一类模型的分词器与上下文限制。
+type ModelSpec struct {
+	Pattern         string `json:"pattern"`          // 模型 ID 匹配规则，以 * 结尾表示前缀匹配，不区分大小写
+	Encoding        string `json:"encoding"`         // 分词器编码，留空或 estimate 时按字符估算
+	ContextWindow   int    `json:"context_window"`   // 上下文窗口（输入 + 输出）
+	MaxOutput       int    `json:"max_output"`       // 单次回复的最大输出 Token
+	MessageOverhead int    `json:"message_overhead"` // 每条消息的角色与分隔符开销
+}
+
+func (s ModelSpec) Validate() error {
+	var errs []error
+	if s.Pattern == "" {
+		errs = append(errs, errors.New("pattern is required"))
+	}
+	switch s.Encoding {
+	case "", EncodingEstimate, EncodingCL100K, EncodingO200K, "p50k_base", "r50k_base":
+	default:
+		errs = append(errs, fmt.Errorf("unknown encoding %q", s.Encoding))
+	}
+	if s.ContextWindow <= 0 {
+		errs = append(errs, fmt.Errorf("context_window must be positive, got %d", s.ContextWindow))
+	}
+	if s.MaxOutput < 0 || s.MaxOutput >= s.ContextWindow {
+		errs = append(errs, fmt.Errorf("max_output must be in [0, context_window), got %d", s.MaxOutput))
+	}
+	if s.MessageOverhead < 0 {
+		errs = append(errs, fmt.Errorf("message_overhead must not be negative, got %d", s.MessageOverhead))
+	}
+	return errors.Join(errs...)
+}
+
+// match 判断模型 ID 是否命中规则；带供应商前缀的 ID（如 openai/gpt-4o）同时按去掉前缀后的部分匹配。
+func (s ModelSpec) match(modelID string) bool {
+	id := strings.ToLower(modelID)
+	pattern := strings.ToLower(s.Pattern)
+	candidates := []string{id}
+	if i := strings.LastIndex(id, "/"); i >= 0 {
+		candidates = append(candidates, id[i+1:])
+	}
+	for _, c := range candidates {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(c, prefix) {
+				return true
+			}
+		} else if c == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultSpec 用于未指定模型的场景，沿用 cl100k_base 口径。
+var defaultSpec = ModelSpec{Pattern: "", Encoding: EncodingCL100K, ContextWindow: 8192, MaxOutput: 2048, MessageOverhead: 3}
+
+// fallbackSpec 用于目录中未登记的模型，按字符估算并采用保守的窗口。
+var fallbackSpec = ModelSpec{Pattern: "*", Encoding: EncodingEstimate, ContextWindow: 8192, MaxOutput: 2048, MessageOverhead: 4}
+
+// builtinCatalog 是内置模型目录，按顺序匹配，越具体的规则越靠前。
+var builtinCatalog = []ModelSpec{
+	{Pattern: "gpt-4o*", Encoding: EncodingO200K, ContextWindow: 128000, MaxOutput: 16384, MessageOverhead: 3},
+	{Pattern: "gpt-4.1*", Encoding: EncodingO200K, ContextWindow: 1047576, MaxOutput: 32768, MessageOverhead: 3},
+	{Pattern: "gpt-5*", Encoding: EncodingO200K, ContextWindow: 400000, MaxOutput: 128000, MessageOverhead: 3},
+	{Pattern: "o1*", Encoding: EncodingO200K, ContextWindow: 200000, MaxOutput: 100000, MessageOverhead: 3},
+	{Pattern: "o3*", Encoding: EncodingO200K, ContextWindow: 200000, MaxOutput: 100000, MessageOverhead: 3},
+	{Pattern: "o4*", Encoding: EncodingO200K, ContextWindow: 200000, MaxOutput: 100000, MessageOverhead: 3},
+	{Pattern: "gpt-4-turbo*", Encoding: EncodingCL100K, ContextWindow: 128000, MaxOutput: 4096, MessageOverhead: 3},
+	{Pattern: "gpt-4-32k*", Encoding: EncodingCL100K, ContextWindow: 32768, MaxOutput: 4096, MessageOverhead: 3},
+	{Pattern: "gpt-4*", Encoding: EncodingCL100K, ContextWindow: 8192, MaxOutput: 4096, MessageOverhead: 3},
+	{Pattern: "gpt-3.5-turbo*", Encoding: EncodingCL100K, ContextWindow: 16385, MaxOutput: 4096, MessageOverhead: 3},
+	{Pattern: "deepseek-*", Encoding: EncodingEstimate, ContextWindow: 64000, MaxOutput: 8192, MessageOverhead: 4},
+	{Pattern: "claude-*", Encoding: EncodingEstimate, ContextWindow: 200000, MaxOutput: 8192, MessageOverhead: 4},
+	{Pattern: "qwen*", Encoding: EncodingEstimate, ContextWindow: 32768, MaxOutput: 8192, MessageOverhead: 4},
+	{Pattern: "glm-4*", Encoding: EncodingEstimate, ContextWindow: 128000, MaxOutput: 4096, MessageOverhead: 4},
+}
+
+var (
+	catalogMu sync.RWMutex
+	custom    []ModelSpec
+)
+
+// Register 登记自定义模型规格，优先于内置目录匹配。同一批规格保持原有顺序（靠前的先匹配），
+// 后登记的批次优先于先前登记的批次。
+func Register(specs ...ModelSpec) error {
+	var errs []error
+	for i, s := range specs {
+		if err := s.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("models[%d] (%s): %w", i, s.Pattern, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	custom = append(append([]ModelSpec{}, specs...), custom...)
+	return nil
+}
+
+// LoadCatalog 从 JSON 文件（ModelSpec 数组）加载自定义模型目录。
+func LoadCatalog(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read model catalog: %w", err)
+	}
+	var specs []ModelSpec
+	if err := json.Unmarshal(raw, &specs); err != nil {
+		return fmt.Errorf("parse model catalog: %w", err)
+	}
+	return Register(specs...)
+}
+
+// Lookup 返回模型 ID 对应的规格：先查自定义目录，再查内置目录；
+// modelID 为空时返回默认规格，未登记的模型返回按字符估算的兜底规格。
+func Lookup(modelID string) ModelSpec {
+	if modelID == "" {
+		return defaultSpec
+	}
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	for _, s := range custom {
+		if s.match(modelID) {
+			return s
+		}
+	}
+	for _, s := range builtinCatalog {
+		if s.match(modelID) {
+			return s
+		}
+	}
+	return fallbackSpec
+}
+
+// Catalog 返回当前生效的模型目录（自定义规则在前）。
+func Catalog() []ModelSpec {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	out := make([]ModelSpec, 0, len(custom)+len(builtinCatalog))
+	out = append(out, custom...)
+	return append(out, builtinCatalog...)
+}
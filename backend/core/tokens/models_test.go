@@ -0,0 +1,36 @@
+package tokens
+
+import "testing"
+
+func TestRegisterKeepsFileOrder(t *testing.T) {
+	catalogMu.Lock()
+	saved := custom
+	custom = nil
+	catalogMu.Unlock()
+	t.Cleanup(func() {
+		catalogMu.Lock()
+		custom = saved
+		catalogMu.Unlock()
+	})
+
+	specific := ModelSpec{Pattern: "acme-4o*", Encoding: EncodingEstimate, ContextWindow: 128000, MaxOutput: 16384, MessageOverhead: 3}
+	generic := ModelSpec{Pattern: "acme-4*", Encoding: EncodingEstimate, ContextWindow: 8192, MaxOutput: 4096, MessageOverhead: 3}
+	if err := Register(specific, generic); err != nil {
+		t.Fatal(err)
+	}
+	if got := Lookup("acme-4o-mini").Pattern; got != specific.Pattern {
+		t.Errorf("Lookup(acme-4o-mini) matched %q, want %q", got, specific.Pattern)
+	}
+	if got := Lookup("acme-4-turbo").Pattern; got != generic.Pattern {
+		t.Errorf("Lookup(acme-4-turbo) matched %q, want %q", got, generic.Pattern)
+	}
+
+	// 后登记的批次优先
+	override := ModelSpec{Pattern: "acme-4o*", Encoding: EncodingEstimate, ContextWindow: 64000, MaxOutput: 4096, MessageOverhead: 3}
+	if err := Register(override); err != nil {
+		t.Fatal(err)
+	}
+	if got := Lookup("acme-4o-mini").ContextWindow; got != override.ContextWindow {
+		t.Errorf("Lookup(acme-4o-mini).ContextWindow = %d, want %d", got, override.ContextWindow)
+	}
+}
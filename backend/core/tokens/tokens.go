@@ -0,0 +1,102 @@
+// Package tokens 提供统一的 Token 计数，保证截断、预算与调试接口使用相同的口径。
+package tokens
+
+import (
+	"context-fabric/backend/core/domain"
+	"sync"
+	"unicode"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+var (
+	encMu    sync.Mutex
+	encoders = make(map[string]*tiktoken.Tiktoken)
+)
+
+// encoderFor 惰性加载并缓存指定编码器；加载失败同样缓存为 nil，避免每次计数都重试下载。
+func encoderFor(encoding string) *tiktoken.Tiktoken {
+	if encoding == "" || encoding == EncodingEstimate {
+		return nil
+	}
+	encMu.Lock()
+	defer encMu.Unlock()
+	if e, ok := encoders[encoding]; ok {
+		return e
+	}
+	e, _ := tiktoken.GetEncoding(encoding)
+	encoders[encoding] = e
+	return e
+}
+
+// Counter 按某个模型的分词器与消息开销计数，分词器不可用时退化为估算。
+type Counter struct {
+	Spec ModelSpec
+	enc  *tiktoken.Tiktoken
+}
+
+// ForModel 返回模型对应的计数器，modelID 为空时使用默认规格（cl100k_base）。
+func ForModel(modelID string) *Counter {
+	spec := Lookup(modelID)
+	return &Counter{Spec: spec, enc: encoderFor(spec.Encoding)}
+}
+
+// Default 返回未指定模型时使用的计数器。
+func Default() *Counter {
+	return ForModel("")
+}
+
+// Exact 表示计数是否来自真实分词器而非估算。
+func (c *Counter) Exact() bool {
+	return c.enc != nil
+}
+
+// Count 返回文本占用的 Token 数。
+func (c *Counter) Count(text string) int {
+	if c.enc != nil {
+		return len(c.enc.Encode(text, nil, nil))
+	}
+	return estimate(text)
+}
+
+// CountMessage 返回单条消息的 Token 数，包含角色与分隔符等固定开销。
+func (c *Counter) CountMessage(m domain.Message) int {
+	return c.Count(m.Content) + c.Spec.MessageOverhead
+}
+
+// CountMessages 返回消息列表的 Token 总数。
+func (c *Counter) CountMessages(msgs []domain.Message) int {
+	total := 0
+	for _, m := range msgs {
+		total += c.CountMessage(m)
+	}
+	return total
+}
+
+// Count 按默认规格返回文本占用的 Token 数。
+func Count(text string) int {
+	return Default().Count(text)
+}
+
+// CountMessages 按默认规格返回消息列表的 Token 总数。
+func CountMessages(msgs []domain.Message) int {
+	return Default().CountMessages(msgs)
+}
+
+// runeCost 是估算模式下单个字符的 Token 开销（以 1/4 Token 为单位）：
+// 中日韩字符约 1 Token / 字，其余文本约 4 字符 / Token。
+func runeCost(r rune) int {
+	if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+		return 4
+	}
+	return 1
+}
+
+// estimate 在没有可用分词器时估算文本的 Token 数。
+func estimate(text string) int {
+	quarters := 0
+	for _, r := range text {
+		quarters += runeCost(r)
+	}
+	return (quarters + 3) / 4
+}
@@ -0,0 +1,230 @@
+package tokens
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 消息内截断模式。
+const (
+	TrimNone   = "none"   // 放不下的消息整条丢弃
+	TrimMiddle = "middle" // 保留首尾，省略中间部分
+	TrimCode   = "code"   // 优先压缩正文，尽量保持围栏代码块完整
+)
+
+// Markers 是截断时插入在省略位置的可见标记，由调用方按会话语言提供。
+type Markers struct {
+	Elided     func(tokens int) string // 省略了正文中的若干 Token
+	ElidedCode func(lines int) string  // 省略了整个代码块
+}
+
+// DefaultMarkers 是未指定语言时使用的中文标记。
+var DefaultMarkers = Markers{
+	Elided:     func(n int) string { return fmt.Sprintf("\n…[已省略 %d 个 Token]…\n", n) },
+	ElidedCode: func(n int) string { return fmt.Sprintf("\n…[已省略代码块 %d 行]…\n", n) },
+}
+
+// Trim 按默认规格与指定模式将文本压缩到不超过 maxTokens。
+func Trim(text string, maxTokens int, mode string) string {
+	return Default().Trim(text, maxTokens, mode)
+}
+
+// Trim 按指定模式将文本压缩到不超过 maxTokens，未超出时原样返回。
+func (c *Counter) Trim(text string, maxTokens int, mode string) string {
+	return c.TrimWith(text, maxTokens, mode, DefaultMarkers)
+}
+
+// TrimWith 与 Trim 相同，省略位置使用给定的标记。
+func (c *Counter) TrimWith(text string, maxTokens int, mode string, m Markers) string {
+	if c.Count(text) <= maxTokens {
+		return text
+	}
+	if mode == TrimCode {
+		return c.trimCodeAware(text, maxTokens, m)
+	}
+	return c.trimMiddleOut(text, maxTokens, m)
+}
+
+// TrimMiddleOut 保留文本开头与结尾各约一半的 Token，中间以省略标记替代。
+// 结果保证不超过 maxTokens；预算连标记都放不下时返回空串。
+func (c *Counter) TrimMiddleOut(text string, maxTokens int) string {
+	return c.trimMiddleOut(text, maxTokens, DefaultMarkers)
+}
+
+func (c *Counter) trimMiddleOut(text string, maxTokens int, m Markers) string {
+	total := c.Count(text)
+	if total <= maxTokens {
+		return text
+	}
+	keep := maxTokens - c.Count(m.Elided(total))
+	// 切分处重新编码可能与原 Token 边界不一致，超出时逐步收紧
+	for keep > 0 {
+		out := c.middleOut(text, total, keep, m)
+		over := c.Count(out) - maxTokens
+		if over <= 0 {
+			return out
+		}
+		keep -= over
+	}
+	return ""
+}
+
+// middleOut 按 Token 保留首尾共 keep 个 Token。
+func (c *Counter) middleOut(text string, total, keep int, m Markers) string {
+	head := (keep + 1) / 2
+	tail := keep - head
+
+	e := c.enc
+	if e == nil {
+		// 无编码器时按估算口径累计字符开销，在 rune 边界上切分
+		runes := []rune(text)
+		h := runePrefix(runes, head)
+		t := runePrefix(reversed(runes), tail)
+		return string(runes[:h]) + m.Elided(total-keep) + string(runes[len(runes)-t:])
+	}
+	ids := e.Encode(text, nil, nil)
+	// 在 Token 边界切分可能截断多字节字符，去除不完整的字节
+	headText := strings.ToValidUTF8(e.Decode(ids[:head]), "")
+	tailText := strings.ToValidUTF8(e.Decode(ids[len(ids)-tail:]), "")
+	return headText + m.Elided(len(ids)-keep) + tailText
+}
+
+// runePrefix 返回估算开销不超过 budget 个 Token 的最长前缀长度（rune 数）。
+func runePrefix(runes []rune, budget int) int {
+	quarters := 0
+	for i, r := range runes {
+		quarters += runeCost(r)
+		if quarters > budget*4 {
+			return i
+		}
+	}
+	return len(runes)
+}
+
+func reversed(runes []rune) []rune {
+	out := make([]rune, len(runes))
+	for i, r := range runes {
+		out[len(runes)-1-i] = r
+	}
+	return out
+}
+
+// segment 是按围栏代码块切分后的文本片段。
+type segment struct {
+	text string
+	code bool
+}
+
+// splitFences 按 ``` 围栏将文本切分为正文与代码块，未闭合的围栏视为代码块直到文末。
+func splitFences(text string) []segment {
+	var segs []segment
+	var cur strings.Builder
+	inCode := false
+	for _, line := range strings.SplitAfter(text, "\n") {
+		fence := strings.HasPrefix(strings.TrimSpace(line), "```")
+		if fence && !inCode {
+			if cur.Len() > 0 {
+				segs = append(segs, segment{text: cur.String()})
+				cur.Reset()
+			}
+			inCode = true
+			cur.WriteString(line)
+			continue
+		}
+		cur.WriteString(line)
+		if fence && inCode {
+			segs = append(segs, segment{text: cur.String(), code: true})
+			cur.Reset()
+			inCode = false
+		}
+	}
+	if cur.Len() > 0 {
+		segs = append(segs, segment{text: cur.String(), code: inCode})
+	}
+	return segs
+}
+
+// TrimCodeAware 压缩文本时尽量保持围栏代码块完整：
+// 先对最长的正文片段做首尾保留压缩；正文压缩殆尽仍超出时，从中间开始整块省略代码块；
+// 仍然超出（例如单个超大代码块）时，退化为对原文整体首尾保留。
+func (c *Counter) TrimCodeAware(text string, maxTokens int) string {
+	return c.trimCodeAware(text, maxTokens, DefaultMarkers)
+}
+
+func (c *Counter) trimCodeAware(text string, maxTokens int, m Markers) string {
+	// 片段拼接处的 Token 边界可能与分段计数略有出入，超出时收紧预算重试
+	budget := maxTokens
+	for attempt := 0; attempt < 3 && budget > 0; attempt++ {
+		out := c.trimCode(text, budget, m)
+		over := c.Count(out) - maxTokens
+		if over <= 0 {
+			return out
+		}
+		budget -= over
+	}
+	return c.trimMiddleOut(text, maxTokens, m)
+}
+
+// trimCode 按片段计数将文本压缩到约 budget 个 Token，无法做到时返回尽力压缩的结果。
+func (c *Counter) trimCode(text string, budget int, m Markers) string {
+	segs := splitFences(text)
+	orig := make([]string, len(segs))
+	counts := make([]int, len(segs))
+	total := 0
+	for i, s := range segs {
+		orig[i] = s.text
+		counts[i] = c.Count(s.text)
+		total += counts[i]
+	}
+
+	// 1. 压缩正文片段，每次处理当前最长的一段
+	for total > budget {
+		longest := -1
+		for i, s := range segs {
+			if !s.code && counts[i] > c.Count(m.Elided(counts[i])) && (longest < 0 || counts[i] > counts[longest]) {
+				longest = i
+			}
+		}
+		if longest < 0 {
+			break
+		}
+		// 始终基于原始片段压缩，避免省略标记被再次截断
+		target := counts[longest] - (total - budget)
+		trimmed := c.trimMiddleOut(orig[longest], max(target, 0), m)
+		if trimmed == "" {
+			trimmed = m.Elided(counts[longest])
+		}
+		n := c.Count(trimmed)
+		if n >= counts[longest] {
+			break
+		}
+		total += n - counts[longest]
+		counts[longest] = n
+		segs[longest] = segment{text: trimmed}
+	}
+
+	// 2. 从中间向两侧整块省略代码块，保留首尾的代码块
+	for total > budget {
+		var blocks []int
+		for i, s := range segs {
+			if s.code {
+				blocks = append(blocks, i)
+			}
+		}
+		if len(blocks) <= 1 {
+			break
+		}
+		victim := blocks[len(blocks)/2]
+		lines := strings.Count(segs[victim].text, "\n")
+		marker := m.ElidedCode(lines)
+		total += c.Count(marker) - counts[victim]
+		counts[victim] = c.Count(marker)
+		segs[victim] = segment{text: marker}
+	}
+
+	var sb strings.Builder
+	for _, s := range segs {
+		sb.WriteString(s.text)
+	}
+	return sb.String()
+}
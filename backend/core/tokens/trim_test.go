@@ -0,0 +1,135 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// estimator 使用估算口径，测试结果不依赖分词器的下载。
+var estimator = &Counter{}
+
+func TestTrimMiddleOut(t *testing.T) {
+	long := "HEAD " + strings.Repeat("filler words ", 200) + " TAIL"
+	cjk := "开头" + strings.Repeat("中间的内容", 100) + "结尾"
+
+	tests := []struct {
+		name      string
+		text      string
+		max       int
+		wantSame  bool
+		wantEmpty bool
+		keep      []string
+	}{
+		{name: "fits", text: "short text", max: 100, wantSame: true},
+		{name: "exact budget", text: strings.Repeat("abcd", 10), max: 10, wantSame: true},
+		{name: "ascii", text: long, max: 60, keep: []string{"HEAD", "TAIL", "已省略"}},
+		{name: "multibyte", text: cjk, max: 40, keep: []string{"开头", "结尾", "已省略"}},
+		{name: "budget below marker", text: long, max: 3, wantEmpty: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := estimator.TrimMiddleOut(tt.text, tt.max)
+			switch {
+			case tt.wantSame:
+				if got != tt.text {
+					t.Fatalf("got %q, want input unchanged", got)
+				}
+				return
+			case tt.wantEmpty:
+				if got != "" {
+					t.Fatalf("got %q, want empty", got)
+				}
+				return
+			}
+			if n := estimator.Count(got); n > tt.max {
+				t.Errorf("Count = %d, want <= %d", n, tt.max)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("result is not valid UTF-8: %q", got)
+			}
+			for _, s := range tt.keep {
+				if !strings.Contains(got, s) {
+					t.Errorf("result %q lost %q", got, s)
+				}
+			}
+		})
+	}
+}
+
+func TestTrimCodeAware(t *testing.T) {
+	code := func(name string) string {
+		return "```go\nfunc " + name + "() {\n\treturn\n}\n```\n"
+	}
+	prose := strings.Repeat("some explanatory prose here. ", 60)
+
+	t.Run("prose trimmed before code", func(t *testing.T) {
+		text := prose + "\n" + code("keep") + "after"
+		max := estimator.Count(text) / 3
+		got := estimator.TrimCodeAware(text, max)
+		if n := estimator.Count(got); n > max {
+			t.Fatalf("Count = %d, want <= %d", n, max)
+		}
+		if !strings.Contains(got, code("keep")) {
+			t.Errorf("code block was not preserved: %q", got)
+		}
+		if !strings.Contains(got, "已省略") {
+			t.Errorf("missing elision marker: %q", got)
+		}
+	})
+
+	t.Run("middle code block elided first", func(t *testing.T) {
+		text := code("first") + code("middle"+strings.Repeat("X", 400)) + code("last")
+		max := estimator.Count(code("first")+code("last")) + 20
+		got := estimator.TrimCodeAware(text, max)
+		if n := estimator.Count(got); n > max {
+			t.Fatalf("Count = %d, want <= %d", n, max)
+		}
+		if !strings.Contains(got, "func first()") || !strings.Contains(got, "func last()") {
+			t.Errorf("outer code blocks were not preserved: %q", got)
+		}
+		if strings.Contains(got, "func middle") || !strings.Contains(got, "已省略代码块") {
+			t.Errorf("middle code block was not elided: %q", got)
+		}
+	})
+
+	t.Run("single oversized block falls back to middle-out", func(t *testing.T) {
+		text := "```\n" + strings.Repeat("line of code\n", 200) + "```\n"
+		got := estimator.TrimCodeAware(text, 50)
+		if n := estimator.Count(got); n > 50 {
+			t.Fatalf("Count = %d, want <= 50", n)
+		}
+		if !strings.HasPrefix(got, "```") || !strings.HasSuffix(got, "```\n") {
+			t.Errorf("head and tail of the block should be kept: %q", got)
+		}
+	})
+}
+
+func TestTrimDispatch(t *testing.T) {
+	text := strings.Repeat("word ", 100)
+	if got := estimator.Trim(text, 1000, TrimMiddle); got != text {
+		t.Errorf("text within budget was modified")
+	}
+	for _, mode := range []string{TrimMiddle, TrimCode} {
+		if got := estimator.Trim(text, 30, mode); estimator.Count(got) > 30 {
+			t.Errorf("mode %s: Count = %d, want <= 30", mode, estimator.Count(got))
+		}
+	}
+}
+
+func TestTrimWithMarkers(t *testing.T) {
+	m := Markers{
+		Elided:     func(n int) string { return "\n[skipped tokens]\n" },
+		ElidedCode: func(n int) string { return "\n[skipped code]\n" },
+	}
+	prose := strings.Repeat("some explanatory prose here. ", 60)
+	if got := estimator.TrimWith(prose, 40, TrimMiddle, m); !strings.Contains(got, "[skipped tokens]") || strings.Contains(got, "已省略") {
+		t.Errorf("middle: got %q, want the given marker only", got)
+	}
+
+	block := func(name string) string { return "```\n" + name + strings.Repeat("X", 200) + "\n```\n" }
+	text := block("a") + block("b") + block("c")
+	if got := estimator.TrimWith(text, estimator.Count(block("a")+block("c"))+10, TrimCode, m); !strings.Contains(got, "[skipped code]") {
+		t.Errorf("code: got %q, want the code marker", got)
+	}
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU 是并发安全的定长缓存，按最久未使用淘汰；容量不大于 0 时不缓存。
+type LRU[V any] struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List // 队首为最近使用
+	hits     uint64
+	misses   uint64
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func NewLRU[V any](capacity int) *LRU[V] {
+	return &LRU[V]{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get 查找缓存并更新命中统计；值按原样返回，切片、map 等引用类型需由调用方复制后再修改。
+func (c *LRU[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		c.hits++
+		return el.Value.(*lruEntry[V]).value, true
+	}
+	c.misses++
+	var zero V
+	return zero, false
+}
+
+// Put 写入缓存，超出容量时淘汰最久未使用的条目。
+func (c *LRU[V]) Put(key string, value V) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruEntry[V]).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+// Stats 返回命中、未命中次数及当前条目数。
+func (c *LRU[V]) Stats() (hits, misses uint64, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.order.Len()
+}
@@ -2,9 +2,9 @@ module context-fabric/backend
 
 go 1.22.2
 
-require github.com/pkoukk/tiktoken-go v0.1.8
-
 require (
-	github.com/dlclark/regexp2 v1.10.0 // indirect
-	github.com/google/uuid v1.3.0 // indirect
+	github.com/google/uuid v1.3.0
+	github.com/pkoukk/tiktoken-go v0.1.8
 )
+
+require github.com/dlclark/regexp2 v1.10.0 // indirect
@@ -11,6 +11,7 @@ import {
 } from 'lucide-react';
 import type { Session, TraceEvent } from '../../types';
 import { useMemo } from 'react';
+import { withSnapshots } from './messageDiff';
 
 interface SequenceObserverProps {
   currentSession: Session | null;
@@ -87,6 +88,7 @@ export function SequenceObserver({
     // Pipeline
     Truncate: '截断超长文本',
     Complete: 'Pass 执行完成',
+    Skipped: 'Pass 条件未满足已跳过',
     // LLM
     Dispatch: '分发模型请求',
     'Call API': '调用模型接口',
@@ -145,7 +147,7 @@ export function SequenceObserver({
   // 统一预处理 Trace 列表
   const processedTraces = useMemo(() => {
     if (!currentSession || activeTraceIndex === null) return [];
-    const traces = currentSession.messages[activeTraceIndex]?.traces || [];
+    const traces = withSnapshots(currentSession.messages[activeTraceIndex]?.traces || []);
 
     const sorted = [...traces]
       .map((t, originalIdx) => ({ ...t, originalIdx }))
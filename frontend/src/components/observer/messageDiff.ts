@@ -0,0 +1,45 @@
+import type { TraceEvent } from '../../types';
+
+// 与后端 pipeline.MessageDiff 对应：每个 Pass 只记录相对上一个 Pass 的消息变化
+interface SnapshotMessage {
+  role: string;
+  content: string;
+}
+
+interface DiffOp {
+  op: 'insert' | 'remove' | 'modify';
+  index: number;
+  old_index?: number;
+  role?: string;
+  content?: string;
+}
+
+interface MessageDiff {
+  base_count: number;
+  count: number;
+  ops?: DiffOp[];
+}
+
+const applyDiff = (prev: SnapshotMessage[], diff: MessageDiff): SnapshotMessage[] => {
+  const out = [...prev];
+  for (const op of diff.ops || []) {
+    const msg = { role: op.role || '', content: op.content || '' };
+    if (op.op === 'insert') out.splice(op.index, 0, msg);
+    else if (op.op === 'remove') out.splice(op.index, 1);
+    else if (op.op === 'modify') out[op.index] = msg;
+  }
+  return out;
+};
+
+// 按原始顺序回放 Pass Trace 中的差异，为每个 Pass 节点补全 messages 快照供详情展示
+export const withSnapshots = (traces: TraceEvent[]): TraceEvent[] => {
+  let snapshot: SnapshotMessage[] = [];
+  return traces.map((t) => {
+    const diff = t.data?.diff as MessageDiff | undefined;
+    if (!t.data?.is_pass || !diff) return t;
+    // 基线为空表示新一次管线运行的开始
+    if (diff.base_count === 0) snapshot = [];
+    snapshot = applyDiff(snapshot, diff);
+    return { ...t, data: { ...t.data, messages: snapshot } };
+  });
+};
@@ -28,6 +28,11 @@ export QDRANT_COLLECTION="documents"
 export AGENTIC_VECTOR_SIZE=1024 
 export RAG_EMBEDDING_MODEL="embedding-c37c78"
 export AGENTIC_REFLECTION_MODEL="deepseek-chat"
+# 声明式 Pipeline 配置（Pass 顺序与参数），修改后重启 Core 即可生效
+export AGENTIC_PIPELINE_CONFIG="$DATA_DIR/config/pipeline.json"
+# 自定义模型目录与注入模板，追加或覆盖内置的默认值
+export AGENTIC_MODEL_CATALOG="$DATA_DIR/config/models.json"
+export AGENTIC_LOCALE_TEMPLATES="$DATA_DIR/config/locales.json"
 
 echo -e "${BLUE}=======================================================${NC}"
 echo -e "${BLUE}🚀 启动 Agentic (ContextFabric) 全栈环境${NC}"